@@ -0,0 +1,88 @@
+// Package audit records security-relevant and financial actions in the tamper-evident audit
+// log kept in the audit_events table.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"rubxy/clientip"
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin          = "login"
+	ActionLoginFailed    = "login_failed"
+	ActionRegister       = "register"
+	ActionTokenRefresh   = "token_refresh"
+	ActionLogout         = "logout"
+	ActionRoleChange     = "role_change"
+	ActionAccountDisable = "account_disable"
+	ActionAccountEnable  = "account_enable"
+	ActionAccountDelete  = "account_delete"
+	ActionPasswordReset  = "password_reset_forced"
+	ActionPayout         = "payout"
+	ActionActivityAdd    = "activity_add"
+	ActionAdminDIDAdd    = "admin_did_add"
+	ActionDIDCreate      = "did_create"
+	ActionDIDClaim       = "did_claim"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is an action to record. Only a SHA-256 hash of Payload is stored, so the log can
+// prove what was requested without holding the request itself.
+type Event struct {
+	Action  string
+	Actor   string
+	Target  string
+	Outcome string
+	Payload interface{}
+}
+
+// Outcome returns OutcomeSuccess if ok, otherwise OutcomeFailure
+func Outcome(ok bool) string {
+	if ok {
+		return OutcomeSuccess
+	}
+	return OutcomeFailure
+}
+
+// Record appends e to the audit log, with the client IP of r if r is not nil (background jobs
+// have no request). Failures are logged; they never fail the audited action.
+func Record(r *http.Request, e Event) {
+	event := &db.AuditEvent{
+		Action:      e.Action,
+		Actor:       e.Actor,
+		Target:      e.Target,
+		Outcome:     e.Outcome,
+		PayloadHash: payloadHash(e.Payload),
+	}
+	if r != nil {
+		event.IP = clientip.FromRequest(r)
+	}
+	if err := db.AppendAuditEvent(event); err != nil {
+		logger.ErrorLogger.Printf("[AUDIT] Failed to record %s by %s on %s: %v", e.Action, e.Actor, e.Target, err)
+	}
+}
+
+// payloadHash returns the hex SHA-256 of payload's JSON encoding, or "" if there is no payload
+func payloadHash(payload interface{}) string {
+	if payload == nil {
+		return ""
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
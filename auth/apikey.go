@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// API keys look like rbx_<prefix>_<secret>: the prefix finds the stored key, the secret proves possession
+const apiKeyPrefix = "rbx_"
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+// GenerateAPIKey creates a new key for k, filling in its prefix and hash, and returns the
+// plaintext key. The plaintext is never stored and cannot be recovered later.
+func GenerateAPIKey(k *db.APIKey) (string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+
+	k.Prefix = hex.EncodeToString(prefixBytes)
+	key := apiKeyPrefix + k.Prefix + "_" + hex.EncodeToString(secretBytes)
+	k.KeyHash = hashSecret(key)
+	return key, nil
+}
+
+// ValidateAPIKey returns the stored key matching the plaintext key if it is valid, and records its use
+func ValidateAPIKey(key string) (*db.APIKey, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	stored, err := db.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || subtle.ConstantTimeCompare([]byte(stored.KeyHash), []byte(hashSecret(key))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && time.Now().After(*stored.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := db.TouchAPIKey(stored.ID); err != nil {
+		logger.ErrorLogger.Printf("Failed to record use of API key %s: %v", stored.Prefix, err)
+	}
+	return stored, nil
+}
+
+// hashSecret returns the hex SHA-256 of a generated secret. API keys and OAuth client secrets carry
+// 256 bits of randomness, so a fast unsalted hash is enough to make a leaked table useless. Do
+// not use it for low-entropy values such as recovery codes; see hashRecoveryCode.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"rubxy/clientip"
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/didkey"
+	"rubxy/logger"
+)
+
+const (
+	// didChallengeTTL is how long a login nonce can be answered
+	didChallengeTTL = 5 * time.Minute
+	// maxPendingDIDChallenges caps the unanswered, unexpired challenges a DID can have
+	maxPendingDIDChallenges = 5
+	// didChallengesPerIP challenges can be requested from one IP per didChallengeWindow
+	didChallengesPerIP = 30
+	didChallengeWindow = time.Minute
+)
+
+var didChallengeLimiter = newAttemptLimiter(didChallengesPerIP, didChallengeWindow)
+
+type DIDChallengeRequest struct {
+	DID string `json:"did"`
+}
+
+type DIDChallengeResponse struct {
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type DIDVerifyRequest struct {
+	DID       string `json:"did"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+	// Scope is the space-separated list of scopes wanted, as at /get-token
+	Scope string `json:"scope,omitempty"`
+}
+
+// challengeMessage is the exact text a DID holder signs to log in
+func challengeMessage(did, nonce string) string {
+	return fmt.Sprintf("Rubxy login for %s\nNonce: %s", did, nonce)
+}
+
+// HandleDIDChallenge issues a single-use nonce for a DID to sign, to log in or to claim the DID
+// for an account. Only DIDs created through Rubxy get challenges, and challenges are limited per
+// client IP and per DID.
+func HandleDIDChallenge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !didChallengeLimiter.allow(clientip.FromRequest(r)) {
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		var req DIDChallengeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		record, err := db.GetDID(req.DID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to look up DID %s: %v", req.DID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if record == nil || record.CreatedBy == "" {
+			http.Error(w, "DID is not registered with an account", http.StatusNotFound)
+			return
+		}
+		pending, err := db.CountPendingDIDChallenges(req.DID)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to count DID challenges for %s: %v", req.DID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if pending >= maxPendingDIDChallenges {
+			http.Error(w, "Too many open challenges for this DID, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		nonceBytes := make([]byte, 32)
+		if _, err := rand.Read(nonceBytes); err != nil {
+			http.Error(w, "Failed to generate challenge", http.StatusInternalServerError)
+			return
+		}
+		nonce := hex.EncodeToString(nonceBytes)
+		expiresAt := time.Now().Add(didChallengeTTL)
+
+		if err := db.SaveDIDChallenge(nonce, req.DID, expiresAt); err != nil {
+			logger.ErrorLogger.Printf("Failed to store DID challenge for %s: %v", req.DID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(DIDChallengeResponse{
+			Nonce:     nonce,
+			Message:   challengeMessage(req.DID, nonce),
+			ExpiresAt: expiresAt,
+		})
+	}
+}
+
+// Errors returned by VerifyDIDChallenge
+var (
+	ErrDIDChallenge = errors.New("invalid or expired challenge")
+	ErrUnknownDID   = errors.New("unknown DID")
+	ErrDIDSignature = errors.New("signature does not match the DID's public key")
+)
+
+// VerifyDIDChallenge consumes a challenge issued for did and checks the holder's signature over
+// it against the public key in the registry, returning the DID's registry entry. It returns
+// ErrDIDChallenge, ErrUnknownDID or ErrDIDSignature if the holder did not prove they hold the key.
+func VerifyDIDChallenge(did, nonce string, signature []byte) (*db.DIDRecord, error) {
+	// Consume the nonce first so a challenge can only ever be answered once
+	valid, err := db.ConsumeDIDChallenge(nonce, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume challenge: %w", err)
+	}
+	if !valid {
+		return nil, ErrDIDChallenge
+	}
+
+	record, err := db.GetDID(did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up DID: %w", err)
+	}
+	if record == nil || record.CreatedBy == "" {
+		return nil, ErrUnknownDID
+	}
+	publicKey, err := didkey.Parse(record.PublicKey)
+	if err != nil {
+		logger.ErrorLogger.Printf("Stored public key of DID %s is invalid: %v", did, err)
+		return nil, ErrUnknownDID
+	}
+	if !publicKey.Verify([]byte(challengeMessage(did, nonce)), signature) {
+		return record, ErrDIDSignature
+	}
+	return record, nil
+}
+
+// didLoginUsername returns the account a DID logs in as: the holder who claimed it. The operator
+// who created the DID is never used, since the DID's key belongs to someone else.
+func didLoginUsername(record *db.DIDRecord) (string, bool) {
+	return record.Owner, record.Owner != ""
+}
+
+// HandleDIDVerify checks a signature over a challenge message and issues the same
+// access/refresh tokens as HandleToken for the account that claimed the DID, with the DID
+// recorded in the claims. DIDs nobody has claimed cannot log in.
+func HandleDIDVerify(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DIDVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DID == "" || req.Nonce == "" || req.Signature == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		signature, err := didkey.DecodeSignature(req.Signature)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		record, err := VerifyDIDChallenge(req.DID, req.Nonce, signature)
+		switch err {
+		case nil:
+		case ErrDIDChallenge, ErrUnknownDID:
+			logger.InfoLogger.Printf("Failed DID login for %s: %v", req.DID, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case ErrDIDSignature:
+			logger.InfoLogger.Printf("Failed DID login for %s: bad signature", req.DID)
+			if username, ok := didLoginUsername(record); ok {
+				auditLogin(r, username, false, loginMethodDID, "")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		default:
+			logger.ErrorLogger.Printf("Failed to verify DID challenge for %s: %v", req.DID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		username, ok := didLoginUsername(record)
+		if !ok {
+			logger.InfoLogger.Printf("Failed DID login for %s: not claimed by an account", req.DID)
+			http.Error(w, "DID has not been claimed by an account; log in and claim it at POST /me/dids/claim", http.StatusUnauthorized)
+			return
+		}
+		if !checkLogin(w, username) {
+			return
+		}
+		scope, ok := grantUserScope(w, username, req.Scope)
+		if !ok {
+			return
+		}
+		login := Claims{Username: username, DID: req.DID, Scope: scope}
+
+		// The DID key stands in for the password, not for the account's second factor
+		requirement, err := mfaRequirementFor(cfg, username)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to check 2FA status for %s: %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if requirement != mfaNone {
+			logger.InfoLogger.Printf("DID %s accepted for user %s, second factor required", req.DID, username)
+			writeMFAChallenge(w, cfg, login, requirement)
+			return
+		}
+		logger.InfoLogger.Printf("Successful DID login for %s as %s", req.DID, username)
+		auditLogin(r, username, true, loginMethodDID, "")
+
+		writeTokenPair(w, r, cfg, login)
+	}
+}
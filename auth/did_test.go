@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"testing"
+
+	"rubxy/db"
+)
+
+func TestDIDLoginUsername(t *testing.T) {
+	tests := []struct {
+		name   string
+		record db.DIDRecord
+		want   string
+		wantOK bool
+	}{
+		// An operator or admin creates DIDs for end users; the creator is never logged in as
+		{"created by an admin, unclaimed", db.DIDRecord{CreatedBy: "admin"}, "", false},
+		{"created by an admin, claimed by its holder", db.DIDRecord{CreatedBy: "admin", Owner: "alice"}, "alice", true},
+		{"claimed by its creator", db.DIDRecord{CreatedBy: "bob", Owner: "bob"}, "bob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := didLoginUsername(&tt.record)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("didLoginUsername = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
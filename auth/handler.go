@@ -1,17 +1,33 @@
 package auth
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"rubxy/audit"
+	"rubxy/clientip"
 	"rubxy/config"
 	"rubxy/db"
 	"rubxy/logger"
+	"rubxy/notify"
 	"rubxy/users"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type AuthRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Email is where password reset links are sent. It is optional at registration unless
+	// email verification is required.
+	Email string `json:"email,omitempty"`
+	// InviteCode is required at registration when registration is invite-only
+	InviteCode string `json:"invite_code,omitempty"`
+	// Scope is the space-separated list of scopes wanted in the tokens; it defaults to every
+	// scope the user's role allows
+	Scope string `json:"scope,omitempty"`
 }
 
 type TokenResponse struct {
@@ -29,33 +45,142 @@ func HandleToken(cfg *config.Config) http.HandlerFunc {
 
 		if !users.Authenticate(req.Username, req.Password) {
 			logger.InfoLogger.Printf("Failed login attempt: %s", req.Username)
+			auditLogin(r, req.Username, false, loginMethodPassword, "")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		logger.InfoLogger.Printf("Successful login for user: %s", req.Username)
 
-		accessToken, _, err := GenerateToken(req.Username, cfg, false)
-		if err != nil {
-			http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		if !checkLogin(w, req.Username) {
 			return
 		}
-
-		refreshToken, expiresAt, err := GenerateToken(req.Username, cfg, true)
-		if err != nil {
-			http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		scope, ok := grantUserScope(w, req.Username, req.Scope)
+		if !ok {
 			return
 		}
 
-		// Store refresh token in DB
-		err = db.SaveRefreshToken(refreshToken, req.Username, expiresAt)
+		requirement, err := mfaRequirementFor(cfg, req.Username)
 		if err != nil {
-			logger.ErrorLogger.Printf("Failed to insert refresh token: %v", err)
+			logger.ErrorLogger.Printf("Failed to check 2FA status for %s: %v", req.Username, err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		if requirement != mfaNone {
+			logger.InfoLogger.Printf("Password accepted for user %s, second factor required", req.Username)
+			writeMFAChallenge(w, cfg, Claims{Username: req.Username, Scope: scope}, requirement)
+			return
+		}
+		logger.InfoLogger.Printf("Successful login for user: %s", req.Username)
+		auditLogin(r, req.Username, true, loginMethodPassword, "")
+
+		writeTokenPair(w, r, cfg, Claims{Username: req.Username, Scope: scope})
+	}
+}
+
+// grantUserScope returns the scope for tokens issued to username at Rubxy's own login endpoints:
+// the requested scopes if the user's role allows them all, or every scope the role allows if
+// none were requested. Otherwise it writes an error and returns false.
+func grantUserScope(w http.ResponseWriter, username, requested string) (string, bool) {
+	allowed, err := UserScopes(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to look up scopes for %s: %v", username, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	scope, oerr := grantedScope(requested, allowed)
+	if oerr != nil {
+		logger.InfoLogger.Printf("Refused scope %q for user %s", requested, username)
+		http.Error(w, oerr.Description, http.StatusForbidden)
+		return "", false
+	}
+	return scope, true
+}
+
+// Login methods recorded in the payload of login audit events
+const (
+	loginMethodPassword      = "password"
+	loginMethodMFA           = "mfa"
+	loginMethodDID           = "did"
+	loginMethodOAuthPassword = "oauth_password"
+	loginMethodOIDC          = "oidc"
+)
+
+// auditLogin records a successful or failed login of username, through clientID for OAuth2 logins
+func auditLogin(r *http.Request, username string, ok bool, method, clientID string) {
+	action := audit.ActionLogin
+	if !ok {
+		action = audit.ActionLoginFailed
+	}
+	audit.Record(r, audit.Event{
+		Action:  action,
+		Actor:   username,
+		Target:  username,
+		Outcome: audit.Outcome(ok),
+		Payload: map[string]string{"method": method, "client_id": clientID},
+	})
+}
+
+// checkLogin writes an error and returns false if a user whose password was accepted may not log in
+func checkLogin(w http.ResponseWriter, username string) bool {
+	err := users.CheckLogin(username)
+	if err == nil {
+		return true
+	}
+	if err == sql.ErrNoRows {
+		logger.InfoLogger.Printf("Login refused for user %s: account does not exist", username)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	var loginErr *users.LoginError
+	if errors.As(err, &loginErr) {
+		logger.InfoLogger.Printf("Login refused for user %s: %v", username, err)
+		http.Error(w, loginErr.Error(), http.StatusForbidden)
+		return false
+	}
+	logger.ErrorLogger.Printf("Failed to check login status for %s: %v", username, err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+	return false
+}
+
+// maxUserAgentLength caps the user agent stored with a session
+const maxUserAgentLength = 512
+
+// saveSession stores a refresh token with the user agent and IP of the request that created it
+// and returns the session ID
+func saveSession(r *http.Request, refreshToken, username string, expiresAt time.Time) (int64, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return db.SaveRefreshToken(refreshToken, username, expiresAt, userAgent, clientip.FromRequest(r))
+}
 
-		json.NewEncoder(w).Encode(TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken})
+// writeTokenPair starts a session for claims: it stores a refresh token with the request's
+// user agent and IP, issues an access token bound to the session and writes both as a TokenResponse
+func writeTokenPair(w http.ResponseWriter, r *http.Request, cfg *config.Config, claims Claims) {
+	refreshClaims := claims
+	refreshToken, expiresAt, err := SignToken(&refreshClaims, cfg, true)
+	if err != nil {
+		http.Error(w, "Failed to generate refresh token", http.StatusInternalServerError)
+		return
 	}
+
+	// Store refresh token in DB
+	sessionID, err := saveSession(r, refreshToken, claims.Username, expiresAt)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to insert refresh token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	accessClaims := claims
+	accessClaims.SessionID = sessionID
+	accessToken, _, err := SignToken(&accessClaims, cfg, false)
+	if err != nil {
+		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken})
 }
 
 func HandleRefresh(cfg *config.Config) http.HandlerFunc {
@@ -80,22 +205,56 @@ func HandleRefresh(cfg *config.Config) http.HandlerFunc {
 		}
 
 		claims, err := ValidateToken(req.RefreshToken, cfg, true)
-		if err != nil {
+		if err != nil || claims == nil {
 			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
 			return
 		}
 
-		accessToken, _, err := GenerateToken(claims.Username, cfg, false)
+		// OAuth2 refresh tokens must go through /oauth/token so the client is re-authenticated
+		if claims.ClientID != "" {
+			http.Error(w, "Refresh tokens issued to OAuth clients must be used at /oauth/token", http.StatusBadRequest)
+			return
+		}
+
+		// The user's role may have changed since they logged in
+		scopes, err := roleLimitedScopes(claims.Username, claims.Scopes())
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to look up scopes for %s: %v", claims.Username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(scopes) == 0 {
+			http.Error(w, "None of the session's scopes are available to this account any more", http.StatusForbidden)
+			return
+		}
+
+		sessionID, err := db.TouchRefreshToken(req.RefreshToken, clientip.FromRequest(r))
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to record refresh token use: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		accessClaims := &Claims{Username: claims.Username, DID: claims.DID, Scope: strings.Join(scopes, " "), SessionID: sessionID}
+		accessToken, _, err := SignToken(accessClaims, cfg, false)
 		if err != nil {
 			http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
 			return
 		}
 
+		audit.Record(r, audit.Event{
+			Action:  audit.ActionTokenRefresh,
+			Actor:   claims.Username,
+			Target:  strconv.FormatInt(sessionID, 10),
+			Outcome: audit.OutcomeSuccess,
+		})
 		json.NewEncoder(w).Encode(TokenResponse{AccessToken: accessToken})
 	}
 }
 
-func HandleRegister() http.HandlerFunc {
+// HandleRegister creates an account according to REGISTRATION_MODE and, when email
+// verification is required, sends the verification token
+func HandleRegister(cfg *config.Config, notifier notify.Notifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req AuthRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -103,18 +262,48 @@ func HandleRegister() http.HandlerFunc {
 			return
 		}
 
-		if err := users.Register(req.Username, req.Password); err != nil {
-			logger.ErrorLogger.Printf("Registration failed for user %s: %v", req.Username, err)
-			http.Error(w, "Registration failed", http.StatusInternalServerError)
+		reg := users.Registration{Username: req.Username, Password: req.Password, Email: req.Email}
+		if req.InviteCode != "" {
+			reg.InviteCodeHash = hashSecret(req.InviteCode)
+		}
+		pending, err := users.Register(reg)
+		audit.Record(r, audit.Event{
+			Action:  audit.ActionRegister,
+			Actor:   req.Username,
+			Target:  req.Username,
+			Outcome: audit.Outcome(err == nil),
+			Payload: map[string]bool{"invite": req.InviteCode != "", "email": req.Email != ""},
+		})
+		if err != nil {
+			var policyErr *users.PolicyError
+			switch {
+			case errors.As(err, &policyErr):
+				http.Error(w, policyErr.Error(), http.StatusBadRequest)
+			case errors.Is(err, users.ErrRegistrationClosed), errors.Is(err, users.ErrInviteRequired), errors.Is(err, users.ErrInvalidInvite):
+				logger.InfoLogger.Printf("Registration refused for user %s: %v", req.Username, err)
+				http.Error(w, "Registration failed: "+err.Error(), http.StatusForbidden)
+			case errors.Is(err, users.ErrUsernameTaken):
+				http.Error(w, "Registration failed: "+err.Error(), http.StatusConflict)
+			default:
+				logger.ErrorLogger.Printf("Registration failed for user %s: %v", req.Username, err)
+				http.Error(w, "Registration failed", http.StatusInternalServerError)
+			}
 			return
 		}
 
+		message := "User registered"
+		if pending {
+			go sendEmailVerification(cfg, notifier, req.Username, req.Email)
+			message = "User registered, verify your email address before logging in"
+		}
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"message": "User registered"})
+		json.NewEncoder(w).Encode(map[string]string{"message": message})
 	}
 }
 
-func HandleLogout() http.HandlerFunc {
+// HandleLogout ends the session of the refresh token in the body, which also invalidates the
+// session's access tokens. A valid bearer token on the request is revoked too.
+func HandleLogout(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			RefreshToken string `json:"refresh_token"`
@@ -124,13 +313,49 @@ func HandleLogout() http.HandlerFunc {
 			return
 		}
 
-		err := db.RevokeRefreshToken(req.RefreshToken)
+		// An access token sent along must belong to the same user, so one user's token cannot be
+		// used to end another user's session or the other way round
+		var accessClaims *Claims
+		if accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if claims, err := ValidateToken(accessToken, cfg, false); err == nil && claims != nil {
+				refreshClaims, err := ValidateToken(req.RefreshToken, cfg, true)
+				if err != nil || refreshClaims == nil {
+					http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+					return
+				}
+				if refreshClaims.Username != claims.Username {
+					logger.InfoLogger.Printf("Logout rejected: access token of %s sent with a refresh token of %s", claims.Username, refreshClaims.Username)
+					http.Error(w, "Refresh token belongs to another user", http.StatusForbidden)
+					return
+				}
+				accessClaims = claims
+			}
+		}
+
+		sessionID, username, err := db.RevokeRefreshToken(req.RefreshToken)
+		if err == nil {
+			err = RevokeSessionAccess(cfg, username, sessionID)
+		}
 		if err != nil {
 			logger.ErrorLogger.Printf("Logout failed: %v", err)
 			http.Error(w, "Failed to logout", http.StatusInternalServerError)
 			return
 		}
 
+		if accessClaims != nil && accessClaims.ID != "" {
+			if err := RevokeAccessToken(accessClaims); err != nil {
+				logger.ErrorLogger.Printf("Failed to revoke access token of %s on logout: %v", accessClaims.Username, err)
+				http.Error(w, "Failed to logout", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		audit.Record(r, audit.Event{
+			Action:  audit.ActionLogout,
+			Actor:   username,
+			Target:  strconv.FormatInt(sessionID, 10),
+			Outcome: audit.OutcomeSuccess,
+		})
 		json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
 	}
 }
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// Token type hints for introspection and revocation (RFC 7662 / RFC 7009)
+const (
+	hintAccessToken  = "access_token"
+	hintRefreshToken = "refresh_token"
+)
+
+// IntrospectionResponse describes a token (RFC 7662 section 2.2). Inactive tokens carry only Active.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+	DID       string `json:"did,omitempty"`
+}
+
+// parseAnyToken validates token as an access or refresh token, trying the hinted type first,
+// and returns its claims and type, or nil if it is not a valid token of either type
+func parseAnyToken(token, hint string, cfg *config.Config) (*Claims, string) {
+	order := []bool{false, true}
+	if hint == hintRefreshToken {
+		order = []bool{true, false}
+	}
+	for _, isRefresh := range order {
+		if claims, err := ValidateToken(token, cfg, isRefresh); err == nil && claims != nil {
+			if isRefresh {
+				return claims, hintRefreshToken
+			}
+			return claims, hintAccessToken
+		}
+	}
+	return nil, ""
+}
+
+// authenticateClientRequest parses the form and authenticates the calling client,
+// writing an OAuth2 error response and returning nil on failure
+func authenticateClientRequest(w http.ResponseWriter, r *http.Request) *db.OAuthClient {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "Request body must be form encoded"))
+		return nil
+	}
+	client, err := AuthenticateOAuthClient(r)
+	if err != nil {
+		if e, ok := err.(*oauthError); ok {
+			logger.InfoLogger.Printf("[OAUTH] Client authentication failed for %q", r.PostFormValue("client_id"))
+			writeOAuthError(w, e)
+		} else {
+			logger.ErrorLogger.Printf("[OAUTH] Failed to look up client: %v", err)
+			writeOAuthError(w, newOAuthError(http.StatusInternalServerError, "server_error", ""))
+		}
+		return nil
+	}
+	return client
+}
+
+// requireTokenParam writes an OAuth2 error response and returns false if the token parameter is missing
+func requireTokenParam(w http.ResponseWriter, r *http.Request) bool {
+	if r.PostFormValue("token") == "" {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "token is required"))
+		return false
+	}
+	return true
+}
+
+// HandleOAuthIntrospect lets an authenticated client check whether a token is active without
+// knowing the signing secrets
+func HandleOAuthIntrospect(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := authenticateClientRequest(w, r)
+		if client == nil || !requireTokenParam(w, r) {
+			return
+		}
+
+		resp := IntrospectionResponse{}
+		claims, tokenType := parseAnyToken(r.PostFormValue("token"), r.PostFormValue("token_type_hint"), cfg)
+		if claims != nil {
+			resp = IntrospectionResponse{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientID:  claims.ClientID,
+				Username:  claims.Username,
+				TokenType: tokenType,
+				Sub:       claims.Username,
+				JTI:       claims.ID,
+				DID:       claims.DID,
+			}
+			if claims.ExpiresAt != nil {
+				resp.Exp = claims.ExpiresAt.Unix()
+			}
+			if claims.IssuedAt != nil {
+				resp.Iat = claims.IssuedAt.Unix()
+			}
+		}
+
+		logger.InfoLogger.Printf("[OAUTH] Client %s introspected a token (active: %v)", client.ClientID, resp.Active)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// HandleOAuthRevoke revokes an access token (by adding its jti to the denylist) or a refresh token.
+// As RFC 7009 requires, unknown and already invalid tokens are answered with 200.
+func HandleOAuthRevoke(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := authenticateClientRequest(w, r)
+		if client == nil || !requireTokenParam(w, r) {
+			return
+		}
+
+		token := r.PostFormValue("token")
+		claims, tokenType := parseAnyToken(token, r.PostFormValue("token_type_hint"), cfg)
+		if claims == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Clients may revoke their own tokens and first-party tokens, but not other clients' tokens
+		if claims.ClientID != "" && claims.ClientID != client.ClientID {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unauthorized_client", "Token was issued to another client"))
+			return
+		}
+
+		var err error
+		switch {
+		case tokenType == hintRefreshToken:
+			var sessionID int64
+			if sessionID, _, err = db.RevokeRefreshToken(token); err == nil {
+				err = RevokeSessionAccess(cfg, claims.Username, sessionID)
+			}
+		case claims.ID == "":
+			logger.InfoLogger.Printf("[OAUTH] Cannot revoke access token of %s: issued without a jti", claims.Username)
+		default:
+			err = RevokeAccessToken(claims)
+		}
+		if err != nil {
+			logger.ErrorLogger.Printf("[OAUTH] Failed to revoke %s for %s: %v", tokenType, claims.Username, err)
+			writeOAuthError(w, newOAuthError(http.StatusInternalServerError, "server_error", ""))
+			return
+		}
+
+		logger.InfoLogger.Printf("[OAUTH] Client %s revoked %s of %s", client.ClientID, tokenType, claims.Username)
+		w.WriteHeader(http.StatusOK)
+	}
+}
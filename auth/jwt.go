@@ -1,7 +1,10 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"rubxy/config"
@@ -12,19 +15,40 @@ import (
 
 type Claims struct {
 	Username string `json:"username"`
+	// DID is set when the token was obtained by proving control of a DID key
+	DID string `json:"did,omitempty"`
+	// Scope is the space-separated list of scopes granted to the token; empty means unrestricted,
+	// as in tokens issued before logins were scoped
+	Scope string `json:"scope,omitempty"`
+	// ClientID is the OAuth2 client the token was issued to
+	ClientID string `json:"client_id,omitempty"`
+	// SessionID is the session (refresh token) an access token was issued for, so revoking the
+	// session also invalidates its access tokens
+	SessionID int64 `json:"sid,omitempty"`
+	// Purpose marks single-purpose tokens, such as the MFA token issued between login steps,
+	// which are not accepted as access tokens
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Scopes returns the granted scopes, or nil if the token is not limited by scope
+func (c *Claims) Scopes() []string {
+	if c.Scope == "" {
+		return nil
+	}
+	return strings.Fields(c.Scope)
+}
+
 // GenerateToken returns the signed token string and its expiration time
 func GenerateToken(username string, cfg *config.Config, isRefresh bool) (string, time.Time, error) {
-	claims := &Claims{
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.AccessTTL)),
-		},
-	}
+	return SignToken(&Claims{Username: username}, cfg, isRefresh)
+}
+
+// SignToken sets the expiry and a unique ID (jti) on claims and returns the signed token string and its expiration time
+func SignToken(claims *Claims, cfg *config.Config, isRefresh bool) (string, time.Time, error) {
+	ttl := cfg.AccessTTL
 	if isRefresh {
-		claims.RegisteredClaims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(cfg.RefreshTTL))
+		ttl = cfg.RefreshTTL
 	}
 
 	secret := cfg.AccessSecret
@@ -32,15 +56,14 @@ func GenerateToken(username string, cfg *config.Config, isRefresh bool) (string,
 		secret = cfg.RefreshSecret
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(secret))
+	signedToken, err := signClaims(claims, secret, ttl)
 	if err != nil {
 		return "", time.Time{}, err
 	}
 
 	// Store refresh token in DB
 	/* if isRefresh {
-		err := db.SaveRefreshToken(signedToken, username, claims.ExpiresAt.Time)
+		err := db.SaveRefreshToken(signedToken, claims.Username, claims.ExpiresAt.Time)
 		if err != nil {
 			return "", time.Time{}, err
 		}
@@ -49,6 +72,19 @@ func GenerateToken(username string, cfg *config.Config, isRefresh bool) (string,
 	return signedToken, claims.ExpiresAt.Time, nil
 }
 
+// signClaims sets a unique ID (jti), the issue time and an expiry ttl from now on claims and signs them with secret
+func signClaims(claims *Claims, secret string, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims.ID = hex.EncodeToString(jti)
+	claims.RegisteredClaims.IssuedAt = jwt.NewNumericDate(time.Now())
+	claims.RegisteredClaims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl))
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
 // ValidateToken validates token string and returns claims
 func ValidateToken(tokenStr string, cfg *config.Config, isRefresh bool) (*Claims, error) {
 	claims := &Claims{}
@@ -59,16 +95,35 @@ func ValidateToken(tokenStr string, cfg *config.Config, isRefresh bool) (*Claims
 
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil || !token.Valid {
 		return nil, err
 	}
+	if claims.Username == "" {
+		return nil, fmt.Errorf("token has no username")
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("%s token cannot be used here", claims.Purpose)
+	}
 
 	if isRefresh {
 		exists, err := db.CheckRefreshTokenExists(tokenStr)
 		if err != nil || !exists {
 			return nil, fmt.Errorf("refresh token revoked or not found")
 		}
+	} else {
+		if claims.ID != "" {
+			revoked, err := revocations.isRevoked(claims.ID)
+			if err != nil || revoked {
+				return nil, fmt.Errorf("access token revoked")
+			}
+		}
+		if claims.SessionID != 0 {
+			revoked, err := revocations.isRevoked(sessionJTI(claims.SessionID))
+			if err != nil || revoked {
+				return nil, fmt.Errorf("session revoked")
+			}
+		}
 	}
 
 	return claims, nil
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter counts attempts per key, such as failed second factors per user or challenges
+// per client IP, and blocks a key once it reaches max attempts until its window ends. Counts are
+// kept in memory, so each instance enforces its own limit.
+type attemptLimiter struct {
+	max     int
+	window  time.Duration
+	mu      sync.Mutex
+	windows map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, windows: map[string]*attemptWindow{}}
+}
+
+func (l *attemptLimiter) blocked(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window, ok := l.windows[key]
+	return ok && window.count >= l.max && time.Now().Before(window.resetAt)
+}
+
+func (l *attemptLimiter) record(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recordLocked(key)
+}
+
+// allow records an attempt for key and reports whether it is within the limit
+func (l *attemptLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recordLocked(key) <= l.max
+}
+
+// recordLocked counts an attempt for key and returns the count in its current window
+func (l *attemptLimiter) recordLocked(key string) int {
+	now := time.Now()
+	for k, window := range l.windows {
+		if now.After(window.resetAt) {
+			delete(l.windows, k)
+		}
+	}
+	window, ok := l.windows[key]
+	if !ok {
+		window = &attemptWindow{resetAt: now.Add(l.window)}
+		l.windows[key] = window
+	}
+	window.count++
+	return window.count
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	delete(l.windows, key)
+	l.mu.Unlock()
+}
@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/users"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Purposes of the tokens issued between login steps
+const (
+	purposeMFA       = "mfa"
+	purposeMFAEnroll = "mfa_enroll"
+)
+
+const (
+	// mfaTokenTTL is how long a user has to enter their code after their password was accepted
+	mfaTokenTTL = 5 * time.Minute
+	// mfaEnrollTokenTTL is how long an admin who must enroll in 2FA has to finish enrolling
+	mfaEnrollTokenTTL = 15 * time.Minute
+	recoveryCodeCount = 10
+	// mfaMaxFailures wrong codes within mfaFailureWindow lock a user's second factor until the window ends
+	mfaMaxFailures   = 5
+	mfaFailureWindow = 5 * time.Minute
+)
+
+var mfaFailures = newAttemptLimiter(mfaMaxFailures, mfaFailureWindow)
+
+// mfaRequirement is what a user has to do after their password was accepted
+type mfaRequirement int
+
+const (
+	mfaNone mfaRequirement = iota
+	mfaVerify
+	mfaEnroll
+)
+
+// MFAChallengeResponse is returned by /get-token instead of tokens when a second step is needed
+type MFAChallengeResponse struct {
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token"`
+	ExpiresAt             int64  `json:"expires_at"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPConfirmResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaRequirementFor returns whether username must enter a code, or must first enroll because
+// REQUIRE_ADMIN_2FA is set and they are an admin without 2FA
+func mfaRequirementFor(cfg *config.Config, username string) (mfaRequirement, error) {
+	state, err := db.GetTOTPState(username)
+	if err != nil {
+		return mfaNone, err
+	}
+	if state != nil && state.Enabled {
+		return mfaVerify, nil
+	}
+	if cfg.RequireAdmin2FA {
+		role, err := users.Role(username)
+		if err != nil {
+			return mfaNone, err
+		}
+		if role == users.RoleAdmin {
+			return mfaEnroll, nil
+		}
+	}
+	return mfaNone, nil
+}
+
+// writeMFAChallenge answers a password or DID login that needs a second step with a short-lived
+// MFA token carrying the claims the final tokens will have
+func writeMFAChallenge(w http.ResponseWriter, cfg *config.Config, login Claims, requirement mfaRequirement) {
+	purpose, ttl, status := purposeMFA, mfaTokenTTL, http.StatusOK
+	if requirement == mfaEnroll {
+		purpose, ttl, status = purposeMFAEnroll, mfaEnrollTokenTTL, http.StatusForbidden
+	}
+
+	claims := &Claims{Username: login.Username, DID: login.DID, Scope: login.Scope, Purpose: purpose}
+	token, err := signClaims(claims, cfg.AccessSecret, ttl)
+	if err != nil {
+		http.Error(w, "Failed to generate MFA token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired:           requirement == mfaVerify,
+		MFAEnrollmentRequired: requirement == mfaEnroll,
+		MFAToken:              token,
+		ExpiresAt:             claims.ExpiresAt.Unix(),
+	})
+}
+
+// parseMFAToken validates a token issued by writeMFAChallenge for purpose
+func parseMFAToken(tokenStr string, cfg *config.Config, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(cfg.AccessSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, err
+	}
+	if claims.Purpose != purpose || claims.Username == "" {
+		return nil, fmt.Errorf("not an %s token", purpose)
+	}
+	return claims, nil
+}
+
+// verifySecondFactor checks a TOTP code, or failing that an unused recovery code, for a user
+// with 2FA enabled. Accepted codes cannot be used again.
+func verifySecondFactor(cfg *config.Config, username, code string) (bool, error) {
+	state, err := db.GetTOTPState(username)
+	if err != nil || state == nil || !state.Enabled {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(state.Secret, code, time.Now(), state.LastStep); ok {
+		return db.UseTOTPStep(username, step)
+	}
+	code = normalizeRecoveryCode(code)
+	if ok, err := db.UseRecoveryCode(username, hashRecoveryCode(cfg, code)); ok || err != nil {
+		return ok, err
+	}
+	// Codes issued before recovery codes were keyed are stored as a plain SHA-256
+	return db.UseRecoveryCode(username, hashSecret(code))
+}
+
+// checkSecondFactor verifies code for username, enforcing the failure limit
+func checkSecondFactor(cfg *config.Config, username, code string) (bool, error) {
+	if mfaFailures.blocked(username) {
+		return false, nil
+	}
+	ok, err := verifySecondFactor(cfg, username, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		mfaFailures.record(username)
+		return false, nil
+	}
+	mfaFailures.reset(username)
+	return true, nil
+}
+
+// generateRecoveryCodes returns recoveryCodeCount new codes formatted as xxxxx-xxxxx
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode returns the hex HMAC-SHA256 of a normalized recovery code keyed with the
+// server's recovery code secret. A code has only 50 bits of randomness, so an unkeyed hash of
+// every code in a leaked table could be brute-forced.
+func hashRecoveryCode(cfg *config.Config, code string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.RecoveryCodeSecret))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// normalizeRecoveryCode lets recovery codes be typed without the dash, with spaces or in upper case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// HandleTokenMFA completes a login started at /get-token by exchanging the MFA token and a
+// TOTP or recovery code for the usual access and refresh tokens
+func HandleTokenMFA(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MFAToken string `json:"mfa_token"`
+			Code     string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := parseMFAToken(req.MFAToken, cfg, purposeMFA)
+		if err != nil || claims == nil {
+			http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+			return
+		}
+
+		if mfaFailures.blocked(claims.Username) {
+			http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		ok, err := checkSecondFactor(cfg, claims.Username, req.Code)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to verify second factor for %s: %v", claims.Username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			logger.InfoLogger.Printf("Failed second factor for user: %s", claims.Username)
+			auditLogin(r, claims.Username, false, loginMethodMFA, "")
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+		// The account may have been disabled since the password step
+		if !checkLogin(w, claims.Username) {
+			return
+		}
+		logger.InfoLogger.Printf("Successful login with second factor for user: %s", claims.Username)
+		auditLogin(r, claims.Username, true, loginMethodMFA, "")
+
+		writeTokenPair(w, r, cfg, Claims{Username: claims.Username, DID: claims.DID, Scope: claims.Scope})
+	}
+}
+
+// enrollingUser returns the user a 2FA enrollment request is for. It accepts a first-party
+// access token, or the enrollment token an admin gets at /get-token when 2FA is mandatory.
+// Disabled accounts cannot enroll with tokens issued before they were disabled.
+func enrollingUser(r *http.Request, cfg *config.Config) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	username, ok := "", false
+	if claims, err := ValidateToken(token, cfg, false); err == nil && claims != nil {
+		username, ok = claims.Username, claims.ClientID == ""
+	} else if claims, err := parseMFAToken(token, cfg, purposeMFAEnroll); err == nil && claims != nil {
+		username, ok = claims.Username, true
+	}
+	if !ok {
+		return "", false
+	}
+	disabled, err := users.IsDisabled(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to check whether %s is disabled: %v", username, err)
+		return "", false
+	}
+	if disabled {
+		logger.InfoLogger.Printf("2FA enrollment rejected for disabled user %s", username)
+		return "", false
+	}
+	return username, true
+}
+
+// HandleTOTPEnroll starts TOTP enrollment and returns the secret to add to an authenticator
+// app. Enrolling again before confirming replaces the secret.
+func HandleTOTPEnroll(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := enrollingUser(r, cfg)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		pending, err := db.SetPendingTOTPSecret(username, secret)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to store TOTP secret for %s: %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !pending {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		json.NewEncoder(w).Encode(TOTPEnrollResponse{Secret: secret, OTPAuthURI: totpURI(secret, username)})
+	}
+}
+
+// HandleTOTPConfirm enables 2FA once the user proves their authenticator app works, and
+// returns recovery codes. The codes are only ever shown here.
+func HandleTOTPConfirm(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, ok := enrollingUser(r, cfg)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		state, err := db.GetTOTPState(username)
+		if err != nil {
+			logger.ErrorLogger.Printf("Failed to load TOTP state for %s: %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if state == nil || state.Secret == "" {
+			http.Error(w, "Start enrollment at /me/2fa/enroll first", http.StatusBadRequest)
+			return
+		}
+		if state.Enabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		step, ok := matchTOTP(state.Secret, strings.TrimSpace(req.Code), time.Now(), state.LastStep)
+		if !ok {
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+
+		codes, err := generateRecoveryCodes()
+		if err != nil {
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		hashes := make([]string, len(codes))
+		for i, code := range codes {
+			hashes[i] = hashRecoveryCode(cfg, normalizeRecoveryCode(code))
+		}
+		if err := db.EnableTOTP(username, step, hashes); err != nil {
+			logger.ErrorLogger.Printf("Failed to enable TOTP for %s: %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.InfoLogger.Printf("Two-factor authentication enabled for user: %s", username)
+
+		json.NewEncoder(w).Encode(TOTPConfirmResponse{
+			Message:       "Two-factor authentication enabled",
+			RecoveryCodes: codes,
+		})
+	}
+}
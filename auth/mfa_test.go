@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+
+	"rubxy/config"
+)
+
+func TestHashRecoveryCode(t *testing.T) {
+	cfg := &config.Config{RecoveryCodeSecret: "recovery-secret"}
+	other := &config.Config{RecoveryCodeSecret: "other-secret"}
+	code := normalizeRecoveryCode("abcde-fghij")
+	want := hashRecoveryCode(cfg, code)
+
+	// The well-known HMAC-SHA256 example, so the hash stays verifiable outside Rubxy
+	fox := hashRecoveryCode(&config.Config{RecoveryCodeSecret: "key"}, "The quick brown fox jumps over the lazy dog")
+	if fox != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Fatalf("hashRecoveryCode is not HMAC-SHA256: got %s", fox)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       *config.Config
+		typed     string
+		wantMatch bool
+	}{
+		{"same code", cfg, "abcde-fghij", true},
+		{"upper case with a space", cfg, "ABCDE FGHIJ", true},
+		{"without the dash", cfg, "abcdefghij", true},
+		{"other secret", other, "abcde-fghij", false},
+		{"other code", cfg, "abcde-fghik", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hashRecoveryCode(tt.cfg, normalizeRecoveryCode(tt.typed))
+			if (got == want) != tt.wantMatch {
+				t.Errorf("hash of %q matches = %v, want %v", tt.typed, got == want, tt.wantMatch)
+			}
+		})
+	}
+
+	if want == hashSecret(code) {
+		t.Error("recovery code hash equals the unkeyed SHA-256")
+	}
+}
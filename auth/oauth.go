@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rubxy/audit"
+	"rubxy/clientip"
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/users"
+)
+
+// OAuth2 grant types supported by /oauth/token
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+	GrantRefreshToken      = "refresh_token"
+	GrantAuthorizationCode = "authorization_code"
+)
+
+// AllGrantTypes lists the grant types a client may be registered for
+var AllGrantTypes = []string{GrantClientCredentials, GrantPassword, GrantRefreshToken, GrantAuthorizationCode}
+
+// IsValidGrantType reports whether grantType is one of AllGrantTypes
+func IsValidGrantType(grantType string) bool {
+	return containsString(AllGrantTypes, grantType)
+}
+
+const oauthClientIDPrefix = "rbxc_"
+
+// OAuthTokenResponse is a successful token response (RFC 6749 section 5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// oauthError is an error response (RFC 6749 section 5.2)
+type oauthError struct {
+	status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func newOAuthError(status int, code, description string) *oauthError {
+	return &oauthError{status: status, Code: code, Description: description}
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+var errInvalidClient = newOAuthError(http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+
+func writeOAuthError(w http.ResponseWriter, e *oauthError) {
+	if e.Code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rubxy"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// GenerateOAuthClient assigns a new client ID and secret to c, storing only the secret's hash,
+// and returns the plaintext secret
+func GenerateOAuthClient(c *db.OAuthClient) (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+
+	c.ClientID = oauthClientIDPrefix + hex.EncodeToString(idBytes)
+	secret := hex.EncodeToString(secretBytes)
+	c.SecretHash = hashSecret(secret)
+	return secret, nil
+}
+
+// AuthenticateOAuthClient checks client credentials sent with HTTP Basic auth or as
+// client_id/client_secret form parameters, returning the client if they are valid
+func AuthenticateOAuthClient(r *http.Request) (*db.OAuthClient, error) {
+	clientID, secret := clientCredentials(r)
+	if clientID == "" || secret == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := db.GetOAuthClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.RevokedAt != nil ||
+		subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// clientCredentials returns the client ID and secret sent with HTTP Basic auth or as form parameters
+func clientCredentials(r *http.Request) (string, string) {
+	if clientID, secret, ok := r.BasicAuth(); ok {
+		return clientID, secret
+	}
+	return r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+}
+
+// HandleOAuthToken is the OAuth2 token endpoint. It supports the client_credentials, password,
+// refresh_token and authorization_code grants; every grant requires client authentication.
+func HandleOAuthToken(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := authenticateClientRequest(w, r)
+		if client == nil {
+			return
+		}
+
+		grantType := r.PostFormValue("grant_type")
+		if grantType == "" {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "grant_type is required"))
+			return
+		}
+		if !IsValidGrantType(grantType) {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unsupported_grant_type", ""))
+			return
+		}
+		if !containsString(client.GrantTypes, grantType) {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unauthorized_client", "Client may not use the "+grantType+" grant"))
+			return
+		}
+
+		var resp *OAuthTokenResponse
+		var oerr *oauthError
+		switch grantType {
+		case GrantClientCredentials:
+			resp, oerr = clientCredentialsGrant(r, cfg, client)
+		case GrantPassword:
+			resp, oerr = passwordGrant(r, cfg, client)
+		case GrantRefreshToken:
+			resp, oerr = refreshTokenGrant(r, cfg, client)
+		case GrantAuthorizationCode:
+			resp, oerr = authorizationCodeGrant(r, cfg, client)
+		}
+		if oerr != nil {
+			writeOAuthError(w, oerr)
+			return
+		}
+
+		logger.InfoLogger.Printf("[OAUTH] Issued %s token to client %s (scope %q)", grantType, client.ClientID, resp.Scope)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// clientCredentialsGrant issues an access token acting as the client's owner; no refresh token
+// is issued because the client can always authenticate again
+func clientCredentialsGrant(r *http.Request, cfg *config.Config, client *db.OAuthClient) (*OAuthTokenResponse, *oauthError) {
+	scope, oerr := roleGrantedScope(client.Owner, r.PostFormValue("scope"), client.Scopes)
+	if oerr != nil {
+		return nil, oerr
+	}
+	return issueOAuthTokens(r, cfg, Claims{Username: client.Owner, Scope: scope, ClientID: client.ClientID}, false)
+}
+
+func passwordGrant(r *http.Request, cfg *config.Config, client *db.OAuthClient) (*OAuthTokenResponse, *oauthError) {
+	username, password := r.PostFormValue("username"), r.PostFormValue("password")
+	if username == "" || password == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "username and password are required")
+	}
+
+	if !users.Authenticate(username, password) {
+		logger.InfoLogger.Printf("[OAUTH] Failed password grant for %s via client %s", username, client.ClientID)
+		auditLogin(r, username, false, loginMethodOAuthPassword, client.ClientID)
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "Invalid username or password")
+	}
+	if err := users.CheckLogin(username); err != nil {
+		var loginErr *users.LoginError
+		if errors.As(err, &loginErr) {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", loginErr.Error())
+		}
+		logger.ErrorLogger.Printf("[OAUTH] Failed to check login status for %s: %v", username, err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	// The password grant has no second step, so it is not available to accounts that need one
+	if requirement, err := mfaRequirementFor(cfg, username); err != nil {
+		logger.ErrorLogger.Printf("[OAUTH] Failed to check 2FA status for %s: %v", username, err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	} else if requirement != mfaNone {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "This account requires two-factor authentication; use the authorization code flow")
+	}
+	scope, oerr := roleGrantedScope(username, r.PostFormValue("scope"), client.Scopes)
+	if oerr != nil {
+		return nil, oerr
+	}
+	auditLogin(r, username, true, loginMethodOAuthPassword, client.ClientID)
+	return issueOAuthTokens(r, cfg, Claims{Username: username, Scope: scope, ClientID: client.ClientID}, true)
+}
+
+// refreshTokenGrant issues a new access token from a refresh token issued to the same client.
+// The scope may be narrowed but never widened, and loses any scopes the user's role no longer allows.
+func refreshTokenGrant(r *http.Request, cfg *config.Config, client *db.OAuthClient) (*OAuthTokenResponse, *oauthError) {
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "refresh_token is required")
+	}
+
+	claims, err := ValidateToken(refreshToken, cfg, true)
+	if err != nil || claims == nil || claims.ClientID != client.ClientID {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "Invalid, expired or revoked refresh token")
+	}
+
+	allowed, err := UserScopes(claims.Username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH] Failed to look up scopes for %s: %v", claims.Username, err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	scope, oerr := grantedScope(r.PostFormValue("scope"), refreshableScopes(claims.Scopes(), allowed))
+	if oerr != nil {
+		return nil, oerr
+	}
+	sessionID, err := db.TouchRefreshToken(refreshToken, clientip.FromRequest(r))
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH] Failed to record refresh token use: %v", err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionTokenRefresh,
+		Actor:   claims.Username,
+		Target:  strconv.FormatInt(sessionID, 10),
+		Outcome: audit.OutcomeSuccess,
+		Payload: map[string]string{"client_id": client.ClientID},
+	})
+	return issueOAuthTokens(r, cfg, Claims{Username: claims.Username, DID: claims.DID, Scope: scope, ClientID: client.ClientID, SessionID: sessionID}, false)
+}
+
+// grantedScope returns the space-separated scope to grant: the requested scopes if they are all
+// allowed, or every allowed scope if none were requested
+func grantedScope(requested string, allowed []string) (string, *oauthError) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		scopes = allowed
+	}
+	for _, s := range scopes {
+		if !containsString(allowed, s) {
+			return "", newOAuthError(http.StatusBadRequest, "invalid_scope", "Scope "+s+" is not allowed")
+		}
+	}
+	if len(scopes) == 0 {
+		return "", newOAuthError(http.StatusBadRequest, "invalid_scope", "No scopes are allowed")
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// refreshableScopes returns the scopes of a session that a refresh may keep: its OpenID Connect
+// scopes, which no role limits, and the API scopes roleAllowed still includes. nil session
+// scopes (a token from before scopes were issued to users) stand for roleAllowed.
+func refreshableScopes(session, roleAllowed []string) []string {
+	if session == nil {
+		return roleAllowed
+	}
+	scopes := []string{}
+	for _, s := range session {
+		if isOIDCScope(s) || containsString(roleAllowed, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// roleGrantedScope is grantedScope limited to the scopes username's role allows, so a client
+// never gets more than the user it acts for could get at /get-token
+func roleGrantedScope(username, requested string, allowed []string) (string, *oauthError) {
+	limited, err := roleLimitedScopes(username, allowed)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH] Failed to look up scopes for %s: %v", username, err)
+		return "", newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	return grantedScope(requested, limited)
+}
+
+// issueOAuthTokens signs an access token for claims and, if withRefresh, starts a session with
+// a stored refresh token that the access token is bound to
+func issueOAuthTokens(r *http.Request, cfg *config.Config, claims Claims, withRefresh bool) (*OAuthTokenResponse, *oauthError) {
+	resp := &OAuthTokenResponse{TokenType: "Bearer", Scope: claims.Scope}
+
+	accessClaims := claims
+	if withRefresh {
+		refreshClaims := claims
+		refreshToken, refreshExpiresAt, err := SignToken(&refreshClaims, cfg, true)
+		if err != nil {
+			logger.ErrorLogger.Printf("[OAUTH] Failed to sign refresh token: %v", err)
+			return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+		}
+		sessionID, err := saveSession(r, refreshToken, claims.Username, refreshExpiresAt)
+		if err != nil {
+			logger.ErrorLogger.Printf("[OAUTH] Failed to store refresh token: %v", err)
+			return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+		}
+		resp.RefreshToken = refreshToken
+		accessClaims.SessionID = sessionID
+	}
+
+	accessToken, expiresAt, err := SignToken(&accessClaims, cfg, false)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH] Failed to sign access token: %v", err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	resp.AccessToken = accessToken
+	resp.ExpiresIn = int(time.Until(expiresAt).Seconds())
+	return resp, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/users"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Connect scopes. They are always allowed in the authorization code flow and do not
+// grant access to any API route.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+)
+
+// authCodeTTL is how long an authorization code can be exchanged for tokens
+const authCodeTTL = 2 * time.Minute
+
+// IDTokenClaims are the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	DID               string `json:"did,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserInfo is the userinfo endpoint response
+type UserInfo struct {
+	Sub               string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	DID               string `json:"did,omitempty"`
+}
+
+// HandleOIDCDiscovery serves the OpenID Provider metadata document
+func HandleOIDCDiscovery(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes := append([]string{ScopeOpenID, ScopeProfile}, AllScopes...)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                cfg.Issuer,
+			"authorization_endpoint":                cfg.Issuer + "/oauth/authorize",
+			"token_endpoint":                        cfg.Issuer + "/oauth/token",
+			"userinfo_endpoint":                     cfg.Issuer + "/oauth/userinfo",
+			"jwks_uri":                              cfg.Issuer + "/oauth/jwks",
+			"introspection_endpoint":                cfg.Issuer + "/oauth/introspect",
+			"revocation_endpoint":                   cfg.Issuer + "/oauth/revoke",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 AllGrantTypes,
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"HS256"},
+			"scopes_supported":                      scopes,
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "did"},
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	}
+}
+
+// HandleOIDCJWKS serves an empty key set: ID tokens are signed HS256 with each client's own secret,
+// which clients verify with the secret they were issued rather than a published key
+func HandleOIDCJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{}})
+	}
+}
+
+// authorizeRequest holds the parameters of an authorization request, carried through the login form
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeRequest(values url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+func (a authorizeRequest) formFields() map[string]string {
+	return map[string]string{
+		"response_type":         a.ResponseType,
+		"client_id":             a.ClientID,
+		"redirect_uri":          a.RedirectURI,
+		"scope":                 a.Scope,
+		"state":                 a.State,
+		"nonce":                 a.Nonce,
+		"code_challenge":        a.CodeChallenge,
+		"code_challenge_method": a.CodeChallengeMethod,
+	}
+}
+
+// validate checks an authorization request. Problems with the client or redirect URI are returned
+// as fatal, because they must not be redirected; everything else is returned as an OAuth2 error to
+// send back to the redirect URI.
+func (a authorizeRequest) validate() (client *db.OAuthClient, fatal string, oerr *oauthError) {
+	client, err := db.GetOAuthClient(a.ClientID)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to look up client %s: %v", a.ClientID, err)
+		return nil, "Something went wrong. Please try again later.", nil
+	}
+	if client == nil || client.RevokedAt != nil {
+		return nil, "Unknown application.", nil
+	}
+	if a.RedirectURI == "" || !containsString(client.RedirectURIs, a.RedirectURI) {
+		return nil, "The application sent an unregistered redirect_uri.", nil
+	}
+
+	if !containsString(client.GrantTypes, GrantAuthorizationCode) {
+		return client, "", newOAuthError(http.StatusBadRequest, "unauthorized_client", "Client may not use the authorization code flow")
+	}
+	if a.ResponseType != "code" {
+		return client, "", newOAuthError(http.StatusBadRequest, "unsupported_response_type", "Only response_type=code is supported")
+	}
+	scopes := strings.Fields(a.Scope)
+	if !containsString(scopes, ScopeOpenID) {
+		return client, "", newOAuthError(http.StatusBadRequest, "invalid_scope", "The openid scope is required")
+	}
+	for _, s := range scopes {
+		if !isOIDCScope(s) && !containsString(client.Scopes, s) {
+			return client, "", newOAuthError(http.StatusBadRequest, "invalid_scope", "Scope "+s+" is not allowed")
+		}
+	}
+	if a.CodeChallenge == "" || a.CodeChallengeMethod != "S256" {
+		return client, "", newOAuthError(http.StatusBadRequest, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+	}
+	return client, "", nil
+}
+
+// redirect sends the user agent back to the client with the given parameters and the request's state
+func (a authorizeRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	target, _ := url.Parse(a.RedirectURI)
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if a.State != "" {
+		query.Set("state", a.State)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in to Rubxy</title>
+<style>
+body { font-family: sans-serif; max-width: 22rem; margin: 4rem auto; padding: 0 1rem; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; margin: .4rem 0; }
+input, button { padding: .5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Sign in</h1>
+{{if .ClientName}}<p><strong>{{.ClientName}}</strong> wants to sign you in with Rubxy.</p>{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Fields}}
+<form method="post" action="/oauth/authorize">
+<label>Username <input name="username" value="{{.Username}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Authentication code, if two-factor authentication is enabled <input name="otp" autocomplete="one-time-code"></label>
+{{range $name, $value := .Fields}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<button type="submit">Sign in</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type loginPageData struct {
+	ClientName string
+	Error      string
+	Username   string
+	Fields     map[string]string
+}
+
+func renderLoginPage(w http.ResponseWriter, status int, data loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to render login page: %v", err)
+	}
+}
+
+// HandleOAuthAuthorize is the authorization endpoint. GET shows the login form for a valid
+// authorization request; POST checks the credentials, including the second factor for
+// accounts with 2FA, and redirects back with a code.
+func HandleOAuthAuthorize(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			renderLoginPage(w, http.StatusBadRequest, loginPageData{Error: "Invalid request."})
+			return
+		}
+
+		req := parseAuthorizeRequest(r.Form)
+		client, fatal, oerr := req.validate()
+		if fatal != "" {
+			renderLoginPage(w, http.StatusBadRequest, loginPageData{Error: fatal})
+			return
+		}
+		if oerr != nil {
+			req.redirect(w, r, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+			return
+		}
+
+		page := loginPageData{ClientName: client.Name, Fields: req.formFields()}
+		if r.Method != http.MethodPost {
+			renderLoginPage(w, http.StatusOK, page)
+			return
+		}
+
+		username := r.PostFormValue("username")
+		page.Username = username
+		if !users.Authenticate(username, r.PostFormValue("password")) {
+			logger.InfoLogger.Printf("[OIDC] Failed login for %s via client %s", username, client.ClientID)
+			auditLogin(r, username, false, loginMethodOIDC, client.ClientID)
+			page.Error = "Invalid username or password."
+			renderLoginPage(w, http.StatusUnauthorized, page)
+			return
+		}
+
+		if err := users.CheckLogin(username); err != nil {
+			var loginErr *users.LoginError
+			if !errors.As(err, &loginErr) {
+				logger.ErrorLogger.Printf("[OIDC] Failed to check login status for %s: %v", username, err)
+				page.Error = "Something went wrong. Please try again later."
+				renderLoginPage(w, http.StatusInternalServerError, page)
+				return
+			}
+			page.Error = loginErr.Error() + "."
+			renderLoginPage(w, http.StatusForbidden, page)
+			return
+		}
+
+		requirement, err := mfaRequirementFor(cfg, username)
+		if err != nil {
+			logger.ErrorLogger.Printf("[OIDC] Failed to check 2FA status for %s: %v", username, err)
+			page.Error = "Something went wrong. Please try again later."
+			renderLoginPage(w, http.StatusInternalServerError, page)
+			return
+		}
+		switch requirement {
+		case mfaEnroll:
+			page.Error = "Your account must set up two-factor authentication before signing in to applications."
+			renderLoginPage(w, http.StatusForbidden, page)
+			return
+		case mfaVerify:
+			otp := r.PostFormValue("otp")
+			if otp == "" {
+				page.Error = "Enter the code from your authenticator app or a recovery code."
+				renderLoginPage(w, http.StatusUnauthorized, page)
+				return
+			}
+			ok, err := checkSecondFactor(cfg, username, otp)
+			if err != nil {
+				logger.ErrorLogger.Printf("[OIDC] Failed to verify second factor for %s: %v", username, err)
+				page.Error = "Something went wrong. Please try again later."
+				renderLoginPage(w, http.StatusInternalServerError, page)
+				return
+			}
+			if !ok {
+				logger.InfoLogger.Printf("[OIDC] Failed second factor for %s via client %s", username, client.ClientID)
+				auditLogin(r, username, false, loginMethodOIDC, client.ClientID)
+				page.Error = "Invalid authentication code."
+				renderLoginPage(w, http.StatusUnauthorized, page)
+				return
+			}
+		}
+
+		codeBytes := make([]byte, 32)
+		if _, err := rand.Read(codeBytes); err != nil {
+			req.redirect(w, r, url.Values{"error": {"server_error"}})
+			return
+		}
+		code := hex.EncodeToString(codeBytes)
+		now := time.Now()
+		err = db.SaveOAuthCode(&db.OAuthCode{
+			CodeHash:      hashSecret(code),
+			ClientID:      client.ClientID,
+			Username:      username,
+			RedirectURI:   req.RedirectURI,
+			Scope:         strings.Join(strings.Fields(req.Scope), " "),
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			AuthTime:      now,
+			ExpiresAt:     now.Add(authCodeTTL),
+		})
+		if err != nil {
+			logger.ErrorLogger.Printf("[OIDC] Failed to store authorization code: %v", err)
+			req.redirect(w, r, url.Values{"error": {"server_error"}})
+			return
+		}
+
+		logger.InfoLogger.Printf("[OIDC] User %s signed in to client %s", username, client.ClientID)
+		auditLogin(r, username, true, loginMethodOIDC, client.ClientID)
+		req.redirect(w, r, url.Values{"code": {code}})
+	}
+}
+
+// authorizationCodeGrant exchanges an authorization code and its PKCE verifier for an access
+// token, an ID token and, if the client may refresh, a refresh token
+func authorizationCodeGrant(r *http.Request, cfg *config.Config, client *db.OAuthClient) (*OAuthTokenResponse, *oauthError) {
+	code, verifier := r.PostFormValue("code"), r.PostFormValue("code_verifier")
+	if code == "" || verifier == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+	}
+
+	stored, err := db.ConsumeOAuthCode(hashSecret(code))
+	if err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to consume authorization code: %v", err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	if stored == nil || stored.ClientID != client.ClientID || stored.RedirectURI != r.PostFormValue("redirect_uri") ||
+		pkceChallenge(verifier) != stored.CodeChallenge {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "Invalid, expired or already used authorization code")
+	}
+
+	scope, oerr := codeScope(stored)
+	if oerr != nil {
+		return nil, oerr
+	}
+	claims := Claims{Username: stored.Username, Scope: scope, ClientID: client.ClientID}
+	resp, oerr := issueOAuthTokens(r, cfg, claims, containsString(client.GrantTypes, GrantRefreshToken))
+	if oerr != nil {
+		return nil, oerr
+	}
+
+	_, secret := clientCredentials(r)
+	resp.IDToken, err = signIDToken(cfg, stored, secret)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to sign ID token: %v", err)
+		return nil, newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	return resp, nil
+}
+
+// pkceChallenge returns the S256 code challenge for a verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// signIDToken issues the ID token for a consumed authorization code, signed HS256 with the client's
+// secret (OpenID Connect Core 10.1) so the client can verify it and no other party can forge it
+func signIDToken(cfg *config.Config, code *db.OAuthCode, clientSecret string) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer,
+			Subject:   code.Username,
+			Audience:  jwt.ClaimStrings{code.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTTL)),
+		},
+	}
+	if containsString(strings.Fields(code.Scope), ScopeProfile) {
+		info := userInfo(code.Username, code.Scope)
+		claims.PreferredUsername = info.PreferredUsername
+		claims.DID = info.DID
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(clientSecret))
+}
+
+// isOIDCScope reports whether scope is one of the OpenID Connect scopes, which release claims
+// about the user rather than access to the API
+func isOIDCScope(scope string) bool {
+	return scope == ScopeOpenID || scope == ScopeProfile
+}
+
+// codeScope returns the scope approved with an authorization code, without any API scopes the
+// user's role does not allow. The OpenID Connect scopes are always kept.
+func codeScope(code *db.OAuthCode) (string, *oauthError) {
+	allowed, err := UserScopes(code.Username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to look up scopes for %s: %v", code.Username, err)
+		return "", newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+	scopes := []string{}
+	for _, s := range strings.Fields(code.Scope) {
+		if isOIDCScope(s) || containsString(allowed, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// userInfo returns the claims about username released for scope. The DID is the user's admin DID
+// when they own exactly one.
+func userInfo(username, scope string) UserInfo {
+	info := UserInfo{Sub: username}
+	if !containsString(strings.Fields(scope), ScopeProfile) {
+		return info
+	}
+	info.PreferredUsername = username
+
+	admins, err := db.ListAdminDIDs(username, db.AdminDIDActive)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OIDC] Failed to look up admin DIDs of %s: %v", username, err)
+	} else if len(admins) == 1 {
+		info.DID = admins[0].DID
+	}
+	return info
+}
+
+// HandleOIDCUserInfo returns the claims about the user an openid access token was issued for
+func HandleOIDCUserInfo(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := ValidateToken(token, cfg, false)
+		if !ok || err != nil || claims == nil || !containsString(claims.Scopes(), ScopeOpenID) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(userInfo(claims.Username, claims.Scope))
+	}
+}
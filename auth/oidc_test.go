@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		want     string
+	}{
+		// RFC 7636 Appendix B
+		{"rfc7636", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		{"empty", "", "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pkceChallenge(tt.verifier); got != tt.want {
+				t.Errorf("pkceChallenge(%q) = %s, want %s", tt.verifier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignIDToken(t *testing.T) {
+	cfg := &config.Config{Issuer: "https://rubxy.example", AccessSecret: "access-secret", AccessTTL: time.Minute}
+	code := &db.OAuthCode{ClientID: "rbxc_test", Username: "alice", Scope: ScopeOpenID, Nonce: "n-0S6", AuthTime: time.Now()}
+	signed, err := signIDToken(cfg, code, "client-secret")
+	if err != nil {
+		t.Fatalf("signIDToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		wantOK bool
+	}{
+		{"client secret", "client-secret", true},
+		{"access secret", "access-secret", false},
+		{"other client secret", "other-secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &IDTokenClaims{}
+			_, err := jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+				return []byte(tt.key), nil
+			}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience("rbxc_test"), jwt.WithIssuer(cfg.Issuer))
+			if (err == nil) != tt.wantOK {
+				t.Fatalf("parse with %s: err = %v, want ok = %v", tt.name, err, tt.wantOK)
+			}
+			if tt.wantOK && (claims.Subject != "alice" || claims.Nonce != "n-0S6") {
+				t.Errorf("claims = %+v, want subject alice and nonce n-0S6", claims)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsOIDCScopes(t *testing.T) {
+	userScopes := []string{ScopePayoutsRead, ScopeDIDRead}
+	tests := []struct {
+		name      string
+		session   []string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"openid profile only", []string{ScopeOpenID, ScopeProfile}, "", "openid profile", false},
+		{"narrowed to openid", []string{ScopeOpenID, ScopeProfile}, "openid", "openid", false},
+		{"API scope the role lost", []string{ScopeOpenID, ScopePayoutsWrite, ScopePayoutsRead}, "", "openid payouts:read", false},
+		{"widening", []string{ScopeOpenID}, "openid payouts:read", "", true},
+		{"token from before scopes", nil, "", "payouts:read did:read", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, oerr := grantedScope(tt.requested, refreshableScopes(tt.session, userScopes))
+			if (oerr != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("refreshed scope = (%q, %v), want (%q, error %v)", got, oerr, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserInfoAfterRefresh(t *testing.T) {
+	// Nothing has been revoked, and the denylist is not read from the database
+	revocations.mu.Lock()
+	loaded := revocations.loaded
+	revocations.loaded = true
+	revocations.mu.Unlock()
+	t.Cleanup(func() {
+		revocations.mu.Lock()
+		revocations.loaded = loaded
+		revocations.mu.Unlock()
+	})
+
+	cfg := &config.Config{AccessSecret: "access-secret", AccessTTL: time.Minute}
+	scope, oerr := grantedScope("", refreshableScopes([]string{ScopeOpenID, ScopePayoutsRead}, []string{ScopePayoutsRead}))
+	if oerr != nil {
+		t.Fatalf("refresh scope: %v", oerr)
+	}
+	claims := Claims{Username: "alice", Scope: scope, ClientID: "rbxc_test"}
+	token, _, err := SignToken(&claims, cfg, false)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	HandleOIDCUserInfo(cfg)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("userinfo after refresh = %d %s, want 200", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	var info UserInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil || info.Sub != "alice" {
+		t.Errorf("userinfo = %+v (%v), want sub alice", info, err)
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rubxy/clientip"
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/notify"
+	"rubxy/users"
+)
+
+// notifyTimeout bounds how long delivering a reset link may take
+const notifyTimeout = 30 * time.Second
+
+// Password reset requests are limited per username, so a user's inbox cannot be flooded, and
+// per client IP; at most maxPendingResets links are being sent at any time
+const (
+	resetRequestsPerUser = 3
+	resetRequestsPerIP   = 20
+	resetRequestWindow   = time.Hour
+	maxPendingResets     = 16
+)
+
+var (
+	resetUserLimiter = newAttemptLimiter(resetRequestsPerUser, resetRequestWindow)
+	resetIPLimiter   = newAttemptLimiter(resetRequestsPerIP, resetRequestWindow)
+	pendingResets    = make(chan struct{}, maxPendingResets)
+)
+
+// HandlePasswordResetRequest sends a single-use reset token to a user through notifier. It
+// always answers 202 so the response does not reveal whether the account exists.
+func HandlePasswordResetRequest(cfg *config.Config, notifier notify.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !resetIPLimiter.allow(clientip.FromRequest(r)) {
+			http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		// Requests over the per-user limit get the same answer, so it does not reveal the account
+		if resetUserLimiter.allow(req.Username) {
+			QueuePasswordReset(cfg, notifier, req.Username)
+		} else {
+			logger.InfoLogger.Printf("[PASSWORD RESET] Too many reset requests for %s", req.Username)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "If the account exists, a password reset link has been sent"})
+	}
+}
+
+// QueuePasswordReset sends a password reset in the background, unless maxPendingResets are
+// already being sent, and reports whether it was queued
+func QueuePasswordReset(cfg *config.Config, notifier notify.Notifier, username string) bool {
+	select {
+	case pendingResets <- struct{}{}:
+	default:
+		logger.ErrorLogger.Printf("[PASSWORD RESET] Dropped reset for %s: too many resets pending", username)
+		return false
+	}
+	go func() {
+		defer func() { <-pendingResets }()
+		SendPasswordReset(cfg, notifier, username)
+	}()
+	return true
+}
+
+// SendPasswordReset stores a single-use reset token for a user and delivers it through
+// notifier. Failures are only logged, since callers do not reveal whether the account exists.
+func SendPasswordReset(cfg *config.Config, notifier notify.Notifier, username string) {
+	email, err := users.Email(username)
+	if err != nil {
+		logger.InfoLogger.Printf("[PASSWORD RESET] Reset requested for unknown user %s", username)
+		return
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to generate token: %v", err)
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	expiresAt := time.Now().Add(cfg.PasswordResetTTL)
+	if err := db.SavePasswordReset(hashSecret(token), username, expiresAt); err != nil {
+		logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to store token for %s: %v", username, err)
+		return
+	}
+
+	body := fmt.Sprintf("A password reset was requested for your Rubxy account %s.\n\n", username)
+	if link := linkWithToken(cfg.PasswordResetURL, token); link != "" {
+		body += "Open this link to choose a new password:\n\n" + link + "\n\n"
+	} else {
+		body += "Send this token with your new password to POST /password/reset/confirm:\n\n" + token + "\n\n"
+	}
+	body += fmt.Sprintf("It expires at %s and can only be used once. If you did not ask for this, ignore this message.\n",
+		expiresAt.UTC().Format(time.RFC1123))
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	err = notifier.Notify(ctx, notify.Message{
+		Kind:     notify.KindPasswordReset,
+		Username: username,
+		To:       email,
+		Subject:  "Reset your Rubxy password",
+		Body:     body,
+		Data:     map[string]string{"token": token, "expires_at": expiresAt.UTC().Format(time.RFC3339)},
+	})
+	if err != nil {
+		logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to deliver reset token to %s: %v", username, err)
+		return
+	}
+	logger.InfoLogger.Printf("[PASSWORD RESET] Reset token sent to %s", username)
+}
+
+// linkWithToken returns base with the token added as a query parameter, or "" if base is not configured
+func linkWithToken(base, token string) string {
+	if base == "" {
+		return ""
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "token=" + url.QueryEscape(token)
+}
+
+// HandlePasswordReset sets a new password with a reset token and logs the user out everywhere
+func HandlePasswordReset(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.NewPassword == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Check the new password before using up the token, so a rejected password can be retried
+		tokenHash := hashSecret(req.Token)
+		username, err := db.PasswordResetUsername(tokenHash)
+		if err != nil {
+			logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to look up token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if username == "" {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		var policyErr *users.PolicyError
+		if err := users.ValidatePassword(username, req.NewPassword); errors.As(err, &policyErr) {
+			http.Error(w, policyErr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		consumed, err := db.ConsumePasswordReset(tokenHash)
+		if err != nil {
+			logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to consume token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if consumed != username {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+
+		if err := users.SetPassword(username, req.NewPassword); err != nil {
+			logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to set password for %s: %v", username, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		revoked, err := RevokeUserSessions(cfg, username, 0)
+		if err != nil {
+			logger.ErrorLogger.Printf("[PASSWORD RESET] Failed to revoke sessions of %s: %v", username, err)
+		}
+		logger.InfoLogger.Printf("[PASSWORD RESET] Password reset for %s, %d sessions revoked", username, len(revoked))
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/notify"
+	"rubxy/users"
+)
+
+// invitePrefix starts every invite code; the full format is inv_<8 hex prefix>_<32 hex secret>
+const invitePrefix = "inv_"
+
+// GenerateInviteCode creates a new code for c, filling in its prefix and hash, and returns the
+// plaintext code. The plaintext is never stored and cannot be recovered later.
+func GenerateInviteCode(c *db.InviteCode) (string, error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", err
+	}
+	secretBytes := make([]byte, 16)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+
+	c.Prefix = hex.EncodeToString(prefixBytes)
+	code := invitePrefix + c.Prefix + "_" + hex.EncodeToString(secretBytes)
+	c.CodeHash = hashSecret(code)
+	return code, nil
+}
+
+// sendEmailVerification sends a single-use token proving the user controls their email address
+func sendEmailVerification(cfg *config.Config, notifier notify.Notifier, username, email string) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		logger.ErrorLogger.Printf("[REGISTER] Failed to generate verification token: %v", err)
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	expiresAt := time.Now().Add(cfg.EmailVerificationTTL)
+	if err := db.SaveEmailVerification(hashSecret(token), username, email, expiresAt); err != nil {
+		logger.ErrorLogger.Printf("[REGISTER] Failed to store verification token for %s: %v", username, err)
+		return
+	}
+
+	body := fmt.Sprintf("Welcome to Rubxy, %s.\n\n", username)
+	if link := linkWithToken(cfg.EmailVerificationURL, token); link != "" {
+		body += "Open this link to verify your email address:\n\n" + link + "\n\n"
+	} else {
+		body += "Send this token to POST /register/verify to verify your email address:\n\n" + token + "\n\n"
+	}
+	body += fmt.Sprintf("It expires at %s. You can log in once your address is verified.\n", expiresAt.UTC().Format(time.RFC1123))
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	err := notifier.Notify(ctx, notify.Message{
+		Kind:     notify.KindEmailVerification,
+		Username: username,
+		To:       email,
+		Subject:  "Verify your email address for Rubxy",
+		Body:     body,
+		Data:     map[string]string{"token": token, "expires_at": expiresAt.UTC().Format(time.RFC3339)},
+	})
+	if err != nil {
+		logger.ErrorLogger.Printf("[REGISTER] Failed to deliver verification token to %s: %v", username, err)
+		return
+	}
+	logger.InfoLogger.Printf("[REGISTER] Verification token sent to %s", username)
+}
+
+// HandleVerifyEmail marks a user's email address as verified with the token sent at registration
+func HandleVerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		username, err := db.ConsumeEmailVerification(hashSecret(req.Token))
+		if err != nil {
+			logger.ErrorLogger.Printf("[REGISTER] Failed to verify email: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if username == "" {
+			http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+			return
+		}
+		logger.InfoLogger.Printf("[REGISTER] Email address verified for %s", username)
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+	}
+}
+
+// HandleResendVerification sends a new verification token to a user whose verification is
+// pending. Like the password reset request it always answers 202.
+func HandleResendVerification(cfg *config.Config, notifier notify.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		go func() {
+			email, err := users.PendingEmail(req.Username)
+			if err != nil {
+				logger.ErrorLogger.Printf("[REGISTER] Failed to look up pending email of %s: %v", req.Username, err)
+				return
+			}
+			if email != "" {
+				sendEmailVerification(cfg, notifier, req.Username, email)
+			}
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "If the account is awaiting verification, a new token has been sent"})
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// revocationStore caches the access token denylist so checking a token does not need a query.
+// Revocations made by this instance apply immediately; those made by other instances are
+// picked up on the next refresh.
+type revocationStore struct {
+	mu     sync.RWMutex
+	jtis   map[string]time.Time
+	loaded bool
+}
+
+var revocations = &revocationStore{jtis: map[string]time.Time{}}
+
+// isRevoked reports whether the access token with this jti has been revoked. Until the cache
+// has been loaded once it asks the database directly.
+func (s *revocationStore) isRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	expiresAt, found := s.jtis[jti]
+	loaded := s.loaded
+	s.mu.RUnlock()
+
+	if found {
+		return time.Now().Before(expiresAt), nil
+	}
+	if loaded {
+		return false, nil
+	}
+	return db.IsAccessTokenRevoked(jti)
+}
+
+func (s *revocationStore) add(jti string, expiresAt time.Time) {
+	s.mu.Lock()
+	s.jtis[jti] = expiresAt
+	s.mu.Unlock()
+}
+
+// refresh reloads the cache from the database and drops expired entries. Unexpired local
+// entries are kept in case they were added while the query was running.
+func (s *revocationStore) refresh() error {
+	jtis, err := db.ActiveRevokedTokens()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for jti, expiresAt := range s.jtis {
+		if _, ok := jtis[jti]; !ok && now.Before(expiresAt) {
+			jtis[jti] = expiresAt
+		}
+	}
+	s.jtis = jtis
+	s.loaded = true
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeAccessToken denylists an access token until it expires
+func RevokeAccessToken(claims *Claims) error {
+	if err := db.RevokeAccessToken(claims.ID, claims.Username, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	revocations.add(claims.ID, claims.ExpiresAt.Time)
+	return nil
+}
+
+// sessionJTI is the denylist entry that revokes every access token of a session
+func sessionJTI(id int64) string {
+	return "sid:" + strconv.FormatInt(id, 10)
+}
+
+// RevokeSessionAccess denylists the access tokens of sessions whose refresh tokens were revoked.
+// Their access tokens expire within AccessTTL, so the entries are kept that long.
+func RevokeSessionAccess(cfg *config.Config, username string, ids ...int64) error {
+	expiresAt := time.Now().Add(cfg.AccessTTL)
+	for _, id := range ids {
+		if err := db.RevokeAccessToken(sessionJTI(id), username, expiresAt); err != nil {
+			return err
+		}
+		revocations.add(sessionJTI(id), expiresAt)
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every session of a user except keep (0 keeps none), including their
+// access tokens, and returns the IDs of the ended sessions
+func RevokeUserSessions(cfg *config.Config, username string, keep int64) ([]int64, error) {
+	ids, err := db.RevokeUserSessions(username, keep)
+	if err != nil {
+		return nil, err
+	}
+	return ids, RevokeSessionAccess(cfg, username, ids...)
+}
+
+// RunRevocationSync loads the access token denylist and then refreshes it every interval until
+// ctx is cancelled. Expired entries are deleted from the database by the janitor.
+func RunRevocationSync(ctx context.Context, interval time.Duration) {
+	if err := revocations.refresh(); err != nil {
+		logger.ErrorLogger.Printf("[REVOCATION] Failed to load denylist: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := revocations.refresh(); err != nil {
+				logger.ErrorLogger.Printf("[REVOCATION] Failed to refresh denylist: %v", err)
+			}
+		}
+	}
+}
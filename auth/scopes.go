@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"database/sql"
+
+	"rubxy/db"
+	"rubxy/users"
+)
+
+// Scopes limit what a credential may do. Routes declare the scope they need with middleware.RequireScope.
+const (
+	ScopePayoutsRead      = "payouts:read"
+	ScopePayoutsAdminRead = "payouts:admin-read"
+	ScopePayoutsWrite     = "payouts:write"
+	ScopeActivitiesRead   = "activities:read"
+	ScopeActivitiesWrite  = "activities:write"
+	ScopeDIDRead          = "did:read"
+	ScopeDIDAdminRead     = "did:admin-read"
+	ScopeDIDCreate        = "did:create"
+	ScopeAdminDIDsWrite   = "admin-dids:write"
+	ScopeAdminManage      = "admin:manage"
+	ScopeCredentials      = "credentials:manage"
+	ScopeExport           = "export:read"
+	ScopeNodeProxy        = "node:proxy"
+)
+
+// AllScopes lists every scope Rubxy understands
+var AllScopes = []string{
+	ScopePayoutsRead,
+	ScopePayoutsAdminRead,
+	ScopePayoutsWrite,
+	ScopeActivitiesRead,
+	ScopeActivitiesWrite,
+	ScopeDIDRead,
+	ScopeDIDAdminRead,
+	ScopeDIDCreate,
+	ScopeAdminDIDsWrite,
+	ScopeAdminManage,
+	ScopeCredentials,
+	ScopeExport,
+	ScopeNodeProxy,
+}
+
+// IsValidScope reports whether scope is one of AllScopes
+func IsValidScope(scope string) bool {
+	return containsString(AllScopes, scope)
+}
+
+// HasScope reports whether scopes grants scope
+func HasScope(scopes []string, scope string) bool {
+	return containsString(scopes, scope)
+}
+
+// userRoleScopes are the scopes available to users without the admin role. They reach only the
+// self-service routes: a user's own payouts, their DIDs, DID creation, their API keys and
+// OAuth2 clients, and the node proxy.
+var userRoleScopes = []string{ScopePayoutsRead, ScopeDIDRead, ScopeDIDCreate, ScopeCredentials, ScopeNodeProxy}
+
+// operatorScopes are the scopes available to users who own an active admin DID, which is what
+// the /admin payout, activity, DID and export routes check; only the admin role may manage accounts
+var operatorScopes = []string{
+	ScopePayoutsRead,
+	ScopePayoutsAdminRead,
+	ScopePayoutsWrite,
+	ScopeActivitiesRead,
+	ScopeActivitiesWrite,
+	ScopeDIDRead,
+	ScopeDIDAdminRead,
+	ScopeDIDCreate,
+	ScopeAdminDIDsWrite,
+	ScopeCredentials,
+	ScopeExport,
+	ScopeNodeProxy,
+}
+
+// RoleScopes returns the scopes a user with role can be granted
+func RoleScopes(role string) []string {
+	if role == users.RoleAdmin {
+		return AllScopes
+	}
+	return userRoleScopes
+}
+
+// UserScopes returns the scopes a user can be granted, according to their current role and
+// whether they own an admin DID. Unknown users, such as accounts deleted since a token was
+// issued, get the user role's scopes.
+func UserScopes(username string) ([]string, error) {
+	role, err := users.Role(username)
+	if err == sql.ErrNoRows {
+		role = users.RoleUser
+	} else if err != nil {
+		return nil, err
+	}
+	if role == users.RoleAdmin {
+		return RoleScopes(role), nil
+	}
+	owned, err := db.ListAdminDIDs(username, db.AdminDIDActive)
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) > 0 {
+		return operatorScopes, nil
+	}
+	return RoleScopes(role), nil
+}
+
+// roleLimitedScopes returns the scopes that are both in scopes and available to username's role.
+// nil scopes (a token from before scopes were issued to users) stand for every scope.
+func roleLimitedScopes(username string, scopes []string) ([]string, error) {
+	allowed, err := UserScopes(username)
+	if err != nil || scopes == nil {
+		return allowed, err
+	}
+	limited := []string{}
+	for _, s := range scopes {
+		if containsString(allowed, s) {
+			limited = append(limited, s)
+		}
+	}
+	return limited, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA-1 seed of the RFC 6238 Appendix B test vectors
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B lists 8 digit codes; 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Key)
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", secret, "050471", 0, step, true},
+		{"lower case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", 0, step, true},
+		{"previous step within skew", secret, totpCode(rfc6238Key, step-1), 0, step - 1, true},
+		{"next step within skew", secret, totpCode(rfc6238Key, step+1), 0, step + 1, true},
+		{"outside skew", secret, totpCode(rfc6238Key, step-2), 0, 0, false},
+		{"already used", secret, "050471", step, 0, false},
+		{"wrong code", secret, "000000", 0, 0, false},
+		{"wrong length", secret, "50471", 0, 0, false},
+		{"invalid secret", "not base32!", "050471", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := matchTOTP(tt.secret, tt.code, now, tt.lastStep)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("matchTOTP = (%d, %v), want (%d, %v)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
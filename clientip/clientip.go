@@ -0,0 +1,25 @@
+// Package clientip works out the address a request came from when Rubxy runs behind a reverse proxy.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// FromRequest returns the first address in X-Forwarded-For, else X-Real-Ip, else the
+// connection's remote address without the port. The headers are trusted, so Rubxy must
+// only be reachable through a proxy that sets them.
+func FromRequest(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(first)
+	}
+	if xri := r.Header.Get("X-Real-Ip"); xri != "" {
+		return strings.TrimSpace(xri)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,50 @@
+// Command audit-verify checks the hash chain of the audit log in the database configured by
+// DATABASE_URL (or .env, as for the server), without creating or migrating any tables. It exits
+// with status 1 if an event was changed, removed or reordered without rewriting every later
+// event, naming the first event that does not verify, and prints the head it reached.
+//
+// The chain alone cannot show that the newest events were deleted or that the whole log was
+// rewritten. Store the printed head outside the database and pass it to the next run with
+// -count and -head, which then also fails unless the log still passes through that head.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"rubxy/config"
+	"rubxy/db"
+)
+
+func main() {
+	var anchor db.AuditHead
+	flag.Int64Var(&anchor.Count, "count", 0, "event count of a head recorded by an earlier run")
+	flag.StringVar(&anchor.Hash, "head", "", "hash of a head recorded by an earlier run")
+	flag.Parse()
+	if (anchor.Count == 0) != (anchor.Hash == "") {
+		fmt.Fprintln(os.Stderr, "-count and -head must be given together")
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	if err := db.Open(cfg.DatabaseURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open the database: %v\n", err)
+		os.Exit(2)
+	}
+
+	head, err := db.VerifyAuditChain(context.Background(), anchor)
+	var chainErr *db.AuditChainError
+	switch {
+	case errors.As(err, &chainErr):
+		fmt.Printf("FAILED: %v (%d events verified before it)\n", chainErr, head.Count)
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Failed to verify audit log: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Printf("OK: %d audit events verified, head %s\n", head.Count, head.Hash)
+	fmt.Printf("Check later runs against this head with: -count %d -head %s\n", head.Count, head.Hash)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccountDetails is an account as shown to admins, without its credentials
+type AccountDetails struct {
+	Account
+	Email       string     `json:"email"`
+	EmailStatus string     `json:"email_status"`
+	Role        string     `json:"role"`
+	TOTPEnabled bool       `json:"totp_enabled"`
+	Disabled    bool       `json:"disabled"`
+	DisabledAt  *time.Time `json:"disabled_at,omitempty"`
+}
+
+type AccountFilter struct {
+	// Search matches part of the username or email
+	Search   string
+	Role     string
+	Disabled *bool
+	AfterID  int
+	Limit    int
+}
+
+const accountColumns = `id, username, created_at, email, email_status, role, totp_enabled, disabled_at`
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (*AccountDetails, error) {
+	var a AccountDetails
+	var disabledAt sql.NullTime
+	err := row.Scan(&a.ID, &a.Username, &a.CreatedAt, &a.Email, &a.EmailStatus, &a.Role, &a.TOTPEnabled, &disabledAt)
+	if err != nil {
+		return nil, err
+	}
+	if disabledAt.Valid {
+		a.Disabled = true
+		a.DisabledAt = &disabledAt.Time
+	}
+	return &a, nil
+}
+
+// GetAccount returns an account by username, or nil if it does not exist
+func GetAccount(username string) (*AccountDetails, error) {
+	a, err := scanAccount(DB.QueryRow(`SELECT `+accountColumns+` FROM users WHERE username = $1`, username))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// ListAccounts returns a page of accounts matching the filter, newest first
+func ListAccounts(f AccountFilter) ([]AccountDetails, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Search != "" {
+		add("(username ILIKE $%[1]d OR email ILIKE $%[1]d)", "%"+escapeLike(f.Search)+"%")
+	}
+	if f.Role != "" {
+		add("role = $%d", f.Role)
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			conditions = append(conditions, "disabled_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "disabled_at IS NULL")
+		}
+	}
+	if f.AfterID > 0 {
+		add("id < $%d", f.AfterID)
+	}
+
+	query := `SELECT ` + accountColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []AccountDetails{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *a)
+	}
+	return accounts, rows.Err()
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AdminDID is an admin DID known to Rubxy and the account it belongs to
+type AdminDID struct {
+	DID        string     `json:"did"`
+	AddedByDID string     `json:"added_by_did,omitempty"`
+	Owner      string     `json:"owner"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  string     `json:"revoked_by,omitempty"`
+}
+
+// Admin DID statuses
+const (
+	AdminDIDActive  = "active"
+	AdminDIDRevoked = "revoked"
+)
+
+var (
+	ErrAdminDIDTaken   = errors.New("admin DID belongs to another user")
+	ErrAdminDIDRevoked = errors.New("admin DID has been revoked and cannot be added again")
+)
+
+func createAdminDIDsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS admin_dids (
+		did TEXT PRIMARY KEY,
+		added_by_did TEXT NOT NULL DEFAULT '',
+		owner TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		revoked_at TIMESTAMP,
+		revoked_by TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS admin_dids_owner_idx ON admin_dids (owner);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'admin_dids' table: %v", err)
+	}
+}
+
+// CheckNewAdminDID returns nil if did may be added as an admin DID owned by owner: it is not
+// known yet, or is already active under the same owner. Otherwise it returns ErrAdminDIDTaken
+// or ErrAdminDIDRevoked.
+func CheckNewAdminDID(did, owner string) error {
+	existing, err := GetAdminDID(did)
+	if err != nil || existing == nil {
+		return err
+	}
+	if existing.Status != AdminDIDActive {
+		return ErrAdminDIDRevoked
+	}
+	if existing.Owner != owner {
+		return ErrAdminDIDTaken
+	}
+	return nil
+}
+
+// SaveAdminDID records an active admin DID. A DID that is already known keeps its owner and
+// status: re-adding one's own active DID is a no-op, and CheckNewAdminDID's errors are returned
+// for anything else, so an admin DID can neither be taken over nor revived.
+func SaveAdminDID(a *AdminDID) error {
+	query := `
+	INSERT INTO admin_dids (did, added_by_did, owner, status) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (did) DO NOTHING
+	RETURNING created_at`
+	a.Status = AdminDIDActive
+	err := DB.QueryRow(query, a.DID, a.AddedByDID, a.Owner, a.Status).Scan(&a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return CheckNewAdminDID(a.DID, a.Owner)
+	}
+	return err
+}
+
+// BootstrapAdminDID records an active admin DID unless the DID is already known, so a
+// revocation is not undone by restarting with the same configuration
+func BootstrapAdminDID(did, owner string) error {
+	_, err := DB.Exec(`INSERT INTO admin_dids (did, owner, status) VALUES ($1, $2, $3) ON CONFLICT (did) DO NOTHING`,
+		did, owner, AdminDIDActive)
+	return err
+}
+
+const adminDIDColumns = `did, added_by_did, owner, status, created_at, revoked_at, revoked_by`
+
+func scanAdminDID(row interface{ Scan(...interface{}) error }) (*AdminDID, error) {
+	var a AdminDID
+	var revokedAt sql.NullTime
+	if err := row.Scan(&a.DID, &a.AddedByDID, &a.Owner, &a.Status, &a.CreatedAt, &revokedAt, &a.RevokedBy); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		a.RevokedAt = &revokedAt.Time
+	}
+	return &a, nil
+}
+
+// GetAdminDID returns an admin DID, or nil if Rubxy does not know it
+func GetAdminDID(did string) (*AdminDID, error) {
+	a, err := scanAdminDID(DB.QueryRow(`SELECT `+adminDIDColumns+` FROM admin_dids WHERE did = $1`, did))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// ListAdminDIDs returns admin DIDs, newest first, optionally filtered by owner and status
+func ListAdminDIDs(owner, status string) ([]AdminDID, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if owner != "" {
+		add("owner = $%d", owner)
+	}
+	if status != "" {
+		add("status = $%d", status)
+	}
+
+	query := `SELECT ` + adminDIDColumns + ` FROM admin_dids`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY created_at DESC, did`
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	admins := []AdminDID{}
+	for rows.Next() {
+		a, err := scanAdminDID(rows)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, *a)
+	}
+	return admins, rows.Err()
+}
+
+// RevokeAdminDID marks an active admin DID revoked; it returns false if the DID was not active
+func RevokeAdminDID(did, revokedBy string) (bool, error) {
+	result, err := DB.Exec(`
+	UPDATE admin_dids SET status = $2, revoked_at = $3, revoked_by = $4
+	WHERE did = $1 AND status = $5`, did, AdminDIDRevoked, time.Now(), revokedBy, AdminDIDActive)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetAdminDIDOwner hands an active admin DID to another account; it returns false if the DID was not active
+func SetAdminDIDOwner(did, owner string) (bool, error) {
+	result, err := DB.Exec(`UPDATE admin_dids SET owner = $2 WHERE did = $1 AND status = $3`, did, owner, AdminDIDActive)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
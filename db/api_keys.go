@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is a long-lived credential for service-to-service access. Only a hash of the
+// secret is stored; Prefix identifies the key and is safe to show.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func createAPIKeysTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		prefix TEXT UNIQUE NOT NULL,
+		key_hash TEXT NOT NULL,
+		username TEXT NOT NULL,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT NOW(),
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS api_keys_username_idx ON api_keys (username);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'api_keys' table: %v", err)
+	}
+}
+
+// SaveAPIKey inserts a new API key and fills in its generated fields
+func SaveAPIKey(k *APIKey) error {
+	query := `
+	INSERT INTO api_keys (name, prefix, key_hash, username, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+	return DB.QueryRow(query, k.Name, k.Prefix, k.KeyHash, k.Username, pq.Array(k.Scopes), k.ExpiresAt).Scan(&k.ID, &k.CreatedAt)
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, username, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var k APIKey
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &k.Username, pq.Array(&k.Scopes), &k.CreatedAt,
+		&expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// GetAPIKeyByPrefix returns the key with the given prefix, or nil if there is none
+func GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	k, err := scanAPIKey(DB.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// ListAPIKeys returns the API keys of a user, newest first
+func ListAPIKeys(username string) ([]APIKey, error) {
+	rows, err := DB.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE username = $1 ORDER BY id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that a key was just used
+func TouchAPIKey(id int) error {
+	_, err := DB.Exec(`UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, time.Now())
+	return err
+}
+
+// RevokeAPIKey revokes one of a user's keys; it returns false if the user has no such unrevoked key
+func RevokeAPIKey(id int, username string) (bool, error) {
+	result, err := DB.Exec(`UPDATE api_keys SET revoked_at = $3 WHERE id = $1 AND username = $2 AND revoked_at IS NULL`,
+		id, username, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
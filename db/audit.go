@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AuditEvent is one entry of the audit log. Each event's Hash covers its fields and the Hash
+// of the event before it, so changing, removing or reordering events breaks the chain unless
+// every later event is rewritten too; see AuditHead.
+type AuditEvent struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Action      string    `json:"action"`
+	Actor       string    `json:"actor"`
+	IP          string    `json:"ip"`
+	Target      string    `json:"target"`
+	Outcome     string    `json:"outcome"`
+	PayloadHash string    `json:"payload_hash"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+}
+
+// audit_events rejects updates, deletes and truncation, so rows can only be appended
+func createAuditEventsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGSERIAL PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		outcome TEXT NOT NULL,
+		payload_hash TEXT NOT NULL DEFAULT '',
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+	CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor);
+	CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $fn$
+	BEGIN
+		RAISE EXCEPTION 'audit_events is append-only';
+	END
+	$fn$ LANGUAGE plpgsql;
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_events_no_modify') THEN
+			CREATE TRIGGER audit_events_no_modify BEFORE UPDATE OR DELETE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+		END IF;
+		IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_events_no_truncate') THEN
+			CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+				FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+		END IF;
+	END $$;`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'audit_events' table: %v", err)
+	}
+}
+
+// computeHash returns the chain hash of an event from its fields and PrevHash
+func (e *AuditEvent) computeHash() string {
+	fields, _ := json.Marshal([]string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Action, e.Actor, e.IP, e.Target, e.Outcome, e.PayloadHash,
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditEvent adds an event to the end of the audit log and fills in its ID, time and
+// hashes. Appends are serialized with a transaction-scoped advisory lock so the chain stays
+// linear when several instances write at once.
+func AppendAuditEvent(e *AuditEvent) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, AuditLogLockKey); err != nil {
+		return err
+	}
+	err = tx.QueryRow(`SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&e.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Postgres keeps microseconds; truncating first means the stored time hashes the same
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = e.computeHash()
+	err = tx.QueryRow(`
+	INSERT INTO audit_events (created_at, action, actor, ip, target, outcome, payload_hash, prev_hash, hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		e.CreatedAt, e.Action, e.Actor, e.IP, e.Target, e.Outcome, e.PayloadHash, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const auditEventColumns = `id, created_at, action, actor, ip, target, outcome, payload_hash, prev_hash, hash`
+
+func scanAuditEvent(row interface{ Scan(...interface{}) error }) (*AuditEvent, error) {
+	var e AuditEvent
+	err := row.Scan(&e.ID, &e.CreatedAt, &e.Action, &e.Actor, &e.IP, &e.Target, &e.Outcome, &e.PayloadHash, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+type AuditFilter struct {
+	Action  string
+	Actor   string
+	Target  string
+	Outcome string
+	From    *time.Time
+	To      *time.Time
+	AfterID int64
+	Limit   int
+}
+
+// ListAuditEvents returns a page of audit events matching the filter, newest first
+func ListAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if f.Outcome != "" {
+		add("outcome = $%d", f.Outcome)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+	if f.AfterID > 0 {
+		add("id < $%d", f.AfterID)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// AuditChainError reports the first event at which the audit log's hash chain is broken.
+// EventID is 0 when the log as a whole does not match the head it was checked against.
+type AuditChainError struct {
+	EventID int64
+	Reason  string
+}
+
+func (e *AuditChainError) Error() string {
+	if e.EventID == 0 {
+		return "audit chain broken: " + e.Reason
+	}
+	return fmt.Sprintf("audit chain broken at event %d: %s", e.EventID, e.Reason)
+}
+
+// AuditHead identifies the end of the audit log when it held Count events. The chain alone
+// cannot show that the newest events were removed or that the whole log was rewritten, so a
+// head recorded outside the database is what later verifications are checked against.
+type AuditHead struct {
+	Count int64  `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+// auditChainVerifier checks audit events one at a time, oldest first
+type auditChainVerifier struct {
+	anchor AuditHead
+	head   AuditHead
+}
+
+func (v *auditChainVerifier) check(e *AuditEvent) error {
+	if e.PrevHash != v.head.Hash {
+		return &AuditChainError{EventID: e.ID, Reason: "previous hash does not match the preceding event"}
+	}
+	if e.computeHash() != e.Hash {
+		return &AuditChainError{EventID: e.ID, Reason: "hash does not match the event's contents"}
+	}
+	v.head = AuditHead{Count: v.head.Count + 1, Hash: e.Hash}
+	if v.head.Count == v.anchor.Count && v.head.Hash != v.anchor.Hash {
+		return &AuditChainError{EventID: e.ID, Reason: "event does not match the recorded head"}
+	}
+	return nil
+}
+
+// finish checks that the log reached the recorded head, so events were not removed from its end
+func (v *auditChainVerifier) finish() error {
+	if v.head.Count < v.anchor.Count {
+		return &AuditChainError{Reason: fmt.Sprintf("log has %d events, fewer than the %d of the recorded head", v.head.Count, v.anchor.Count)}
+	}
+	return nil
+}
+
+// VerifyAuditChain recomputes the hash of every audit event, oldest first, and checks that each
+// event points at the one before it. If anchor is not zero, it also checks that the log still
+// passes through that head. It returns the head of the events checked, and an *AuditChainError
+// at the first event that does not verify.
+func VerifyAuditChain(ctx context.Context, anchor AuditHead) (AuditHead, error) {
+	v := &auditChainVerifier{anchor: anchor}
+	rows, err := DB.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return v.head, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return v.head, err
+		}
+		if err := v.check(e); err != nil {
+			return v.head, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return v.head, err
+	}
+	return v.head, v.finish()
+}
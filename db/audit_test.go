@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testAuditChain returns n chained events as AppendAuditEvent would store them
+func testAuditChain(n int) []*AuditEvent {
+	events := []*AuditEvent{}
+	prevHash := ""
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		e := &AuditEvent{
+			ID:        int64(i + 1),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+			Action:    "login",
+			Actor:     "alice",
+			IP:        "192.0.2.1",
+			Outcome:   "success",
+			PrevHash:  prevHash,
+		}
+		e.Hash = e.computeHash()
+		prevHash = e.Hash
+		events = append(events, e)
+	}
+	return events
+}
+
+// verifyEvents runs events through an auditChainVerifier, as VerifyAuditChain does with rows
+func verifyEvents(events []*AuditEvent, anchor AuditHead) (AuditHead, error) {
+	v := &auditChainVerifier{anchor: anchor}
+	for _, e := range events {
+		if err := v.check(e); err != nil {
+			return v.head, err
+		}
+	}
+	return v.head, v.finish()
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	full := testAuditChain(4)
+	anchor := AuditHead{Count: 3, Hash: full[2].Hash}
+
+	tests := []struct {
+		name      string
+		events    func() []*AuditEvent
+		anchor    AuditHead
+		wantCount int64
+		wantEvent int64 // ID in the *AuditChainError, or -1 for no error
+	}{
+		{"intact", func() []*AuditEvent { return testAuditChain(4) }, AuditHead{}, 4, -1},
+		{"empty", func() []*AuditEvent { return nil }, AuditHead{}, 0, -1},
+		{"intact past the anchor", func() []*AuditEvent { return testAuditChain(4) }, anchor, 4, -1},
+		{"tampered field", func() []*AuditEvent {
+			events := testAuditChain(4)
+			events[1].Actor = "mallory"
+			return events
+		}, AuditHead{}, 1, 2},
+		{"tampered field with rehash", func() []*AuditEvent {
+			events := testAuditChain(4)
+			events[1].Outcome = "failure"
+			events[1].Hash = events[1].computeHash()
+			return events
+		}, AuditHead{}, 2, 3},
+		{"removed event", func() []*AuditEvent {
+			events := testAuditChain(4)
+			return append(events[:1], events[2:]...)
+		}, AuditHead{}, 1, 3},
+		{"reordered events", func() []*AuditEvent {
+			events := testAuditChain(4)
+			events[1], events[2] = events[2], events[1]
+			return events
+		}, AuditHead{}, 1, 3},
+		{"newest events removed", func() []*AuditEvent { return testAuditChain(2) }, anchor, 2, 0},
+		{"rewritten log", func() []*AuditEvent {
+			events := testAuditChain(4)
+			for i, e := range events {
+				e.Actor = "mallory"
+				if i > 0 {
+					e.PrevHash = events[i-1].Hash
+				}
+				e.Hash = e.computeHash()
+			}
+			return events
+		}, anchor, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, err := verifyEvents(tt.events(), tt.anchor)
+			if head.Count != tt.wantCount {
+				t.Errorf("verified %d events, want %d", head.Count, tt.wantCount)
+			}
+			if tt.wantEvent < 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var chainErr *AuditChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("error = %v, want an *AuditChainError", err)
+			}
+			if chainErr.EventID != tt.wantEvent {
+				t.Errorf("chain broken at event %d, want %d (%v)", chainErr.EventID, tt.wantEvent, err)
+			}
+		})
+	}
+}
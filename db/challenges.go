@@ -0,0 +1,51 @@
+package db
+
+import (
+	"log"
+	"time"
+)
+
+func createDIDChallengesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS did_challenges (
+		nonce TEXT PRIMARY KEY,
+		did TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT NOW()
+	);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'did_challenges' table: %v", err)
+	}
+}
+
+// SaveDIDChallenge stores a login nonce issued to a DID
+func SaveDIDChallenge(nonce, did string, expiresAt time.Time) error {
+	query := `INSERT INTO did_challenges (nonce, did, expires_at) VALUES ($1, $2, $3)`
+	_, err := DB.Exec(query, nonce, did, expiresAt)
+	return err
+}
+
+// CountPendingDIDChallenges returns how many unused, unexpired challenges did has
+func CountPendingDIDChallenges(did string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM did_challenges WHERE did = $1 AND NOT used AND expires_at > $2`
+	err := DB.QueryRow(query, did, time.Now()).Scan(&count)
+	return count, err
+}
+
+// ConsumeDIDChallenge marks a nonce as used and returns true only if it was issued to did,
+// had not been used and has not expired, so each challenge can be answered at most once
+func ConsumeDIDChallenge(nonce, did string) (bool, error) {
+	query := `UPDATE did_challenges SET used = TRUE WHERE nonce = $1 AND did = $2 AND NOT used AND expires_at > $3`
+	res, err := DB.Exec(query, nonce, did, time.Now())
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// staleRows describes rows of a table that are no longer needed once cutoff has passed. key is
+// the table's primary key, used to delete in batches.
+type staleRows struct {
+	table     string
+	key       string
+	condition string // $1 is the cutoff
+}
+
+// staleRowSets are the tables with expiring rows. Refresh tokens that were revoked are kept
+// until they have not been used for the retention period, so recent sessions stay visible.
+var staleRowSets = []staleRows{
+	{"refresh_tokens", "id", "expires_at < $1 OR (revoked AND COALESCE(last_used_at, created_at) < $1)"},
+	{"revoked_tokens", "jti", "expires_at < $1"},
+	{"password_resets", "token_hash", "expires_at < $1"},
+	{"email_verifications", "token_hash", "expires_at < $1"},
+	{"oauth_codes", "code_hash", "expires_at < $1"},
+	{"did_challenges", "nonce", "expires_at < $1"},
+}
+
+// CleanupTables returns the tables PurgeStaleRows can clean up
+func CleanupTables() []string {
+	tables := make([]string, len(staleRowSets))
+	for i, s := range staleRowSets {
+		tables[i] = s.table
+	}
+	return tables
+}
+
+// PurgeStaleRows deletes up to limit rows of table that expired (or were revoked) before cutoff
+// and returns how many it deleted
+func PurgeStaleRows(ctx context.Context, table string, cutoff time.Time, limit int) (int64, error) {
+	for _, s := range staleRowSets {
+		if s.table != table {
+			continue
+		}
+		query := fmt.Sprintf(`DELETE FROM %[1]s WHERE %[2]s IN (SELECT %[2]s FROM %[1]s WHERE %[3]s LIMIT $2)`,
+			s.table, s.key, s.condition)
+		result, err := DB.ExecContext(ctx, query, cutoff, limit)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+	return 0, fmt.Errorf("no cleanup defined for table %s", table)
+}
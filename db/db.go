@@ -1,8 +1,11 @@
 package db
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -11,11 +14,20 @@ import (
 
 var DB *sql.DB
 
+// Init connects to the database and creates or migrates the tables the server uses
 func Init(databaseURL string) {
+	if err := Open(databaseURL); err != nil {
+		log.Fatalf("%v", err)
+	}
+	createTables()
+}
+
+// Open connects to the database without creating or changing any tables, for tools that only read it
+func Open(databaseURL string) error {
 	var err error
 	DB, err = sql.Open("postgres", databaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to DB: %v", err)
+		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
 
 	// Configure connection pool to prevent connection exhaustion
@@ -25,15 +37,32 @@ func Init(databaseURL string) {
 	DB.SetConnMaxIdleTime(10 * time.Minute) // Maximum idle time before closing
 
 	if err = DB.Ping(); err != nil {
-		log.Fatalf("Database not reachable: %v", err)
+		return fmt.Errorf("database not reachable: %w", err)
 	}
-
-	createTables()
+	return nil
 }
 
 func createTables() {
 	createUsersTable()
 	createRefreshTokensTable()
+	createPayoutSchedulesTable()
+	createPayoutScheduleRunsTable()
+	createPayoutsTable()
+	createActivitiesTable()
+	createReconciliationTables()
+	createDIDsTable()
+	createDIDChallengesTable()
+	createDIDBatchTables()
+	createAdminDIDsTable()
+	createAPIKeysTable()
+	createOAuthClientsTable()
+	createRevokedTokensTable()
+	createOAuthCodesTable()
+	createRecoveryCodesTable()
+	createPasswordResetsTable()
+	createInviteCodesTable()
+	createEmailVerificationsTable()
+	createAuditEventsTable()
 }
 
 func createUsersTable() {
@@ -43,77 +72,97 @@ func createUsersTable() {
 		username TEXT UNIQUE NOT NULL,
 		password_hash TEXT NOT NULL,
 		created_at TIMESTAMP DEFAULT NOW()
-	);`
+	);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email_status TEXT NOT NULL DEFAULT 'unverified';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_id INTEGER;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;`
 	_, err := DB.Exec(query)
 	if err != nil {
 		log.Fatalf("Failed to create 'users' table: %v", err)
 	}
 }
 
+// Refresh tokens are stored as the SHA-256 hash of the signed token, so the table does not hold
+// usable credentials. Sessions are referred to by their ID.
 func createRefreshTokensTable() {
 	query := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
+		id BIGSERIAL PRIMARY KEY,
+		token_hash TEXT UNIQUE NOT NULL,
 		username TEXT NOT NULL,
 		expires_at TIMESTAMP NOT NULL,
 		revoked BOOLEAN DEFAULT FALSE,
 		created_at TIMESTAMP DEFAULT NOW()
-	);`
+	);
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'refresh_tokens' AND column_name = 'id') THEN
+			ALTER TABLE refresh_tokens ADD COLUMN id BIGSERIAL UNIQUE;
+		END IF;
+		-- Tables from before tokens were hashed keep the plaintext token as their primary key
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'refresh_tokens' AND column_name = 'token') THEN
+			ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash TEXT;
+			UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex') WHERE token_hash IS NULL;
+			ALTER TABLE refresh_tokens DROP COLUMN token;
+			ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
+			ALTER TABLE refresh_tokens ADD CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash);
+			ALTER TABLE refresh_tokens ADD PRIMARY KEY (id);
+		END IF;
+	END $$;
+	ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+	CREATE INDEX IF NOT EXISTS refresh_tokens_username_idx ON refresh_tokens (username);
+	CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);`
 	_, err := DB.Exec(query)
 	if err != nil {
 		log.Fatalf("Failed to create 'refresh_tokens' table: %v", err)
 	}
 }
 
-// SaveRefreshToken inserts a refresh token record into DB
-func SaveRefreshToken(token, username string, expiresAt time.Time) error {
-	query := `INSERT INTO refresh_tokens (token, username, expires_at) VALUES ($1, $2, $3)`
-	_, err := DB.Exec(query, token, username, expiresAt)
-	return err
+// hashRefreshToken returns the hex SHA-256 hash a refresh token is stored and looked up by
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveRefreshToken stores the hash of a refresh token and returns its ID, which identifies
+// the session the token belongs to
+func SaveRefreshToken(token, username string, expiresAt time.Time, userAgent, ip string) (int64, error) {
+	query := `INSERT INTO refresh_tokens (token_hash, username, expires_at, user_agent, ip) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	var id int64
+	err := DB.QueryRow(query, hashRefreshToken(token), username, expiresAt, userAgent, ip).Scan(&id)
+	return id, err
 }
 
 // CheckRefreshTokenExists returns true if token exists and is valid (not revoked or expired)
 func CheckRefreshTokenExists(token string) (bool, error) {
-	var revoked bool
-	var expiresAt time.Time
-
-	query := `SELECT revoked, expires_at FROM refresh_tokens WHERE token = $1`
-	err := DB.QueryRow(query, token).Scan(&revoked, &expiresAt)
-	if err == sql.ErrNoRows {
-		return false, nil
-	}
-	if err != nil {
-		return false, err
-	}
-	if revoked || time.Now().After(expiresAt) {
-		return false, nil
-	}
-	return true, nil
+	return IsRefreshTokenValid(token)
 }
 
-// RevokeRefreshToken marks the token as revoked
-func RevokeRefreshToken(token string) error {
-	query := `UPDATE refresh_tokens SET revoked = TRUE WHERE token = $1`
-	res, err := DB.Exec(query, token)
-	if err != nil {
-		return err
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return errors.New("token not found")
+// RevokeRefreshToken marks the token as revoked and returns the ID of its session and its user
+func RevokeRefreshToken(token string) (int64, string, error) {
+	query := `UPDATE refresh_tokens SET revoked = TRUE WHERE token_hash = $1 RETURNING id, username`
+	var id int64
+	var username string
+	err := DB.QueryRow(query, hashRefreshToken(token)).Scan(&id, &username)
+	if err == sql.ErrNoRows {
+		return 0, "", errors.New("token not found")
 	}
-	return nil
+	return id, username, err
 }
 
 func IsRefreshTokenValid(token string) (bool, error) {
 	var revoked bool
 	var expiresAt time.Time
 
-	query := `SELECT revoked, expires_at FROM refresh_tokens WHERE token = $1`
-	err := DB.QueryRow(query, token).Scan(&revoked, &expiresAt)
+	query := `SELECT revoked, expires_at FROM refresh_tokens WHERE token_hash = $1`
+	err := DB.QueryRow(query, hashRefreshToken(token)).Scan(&revoked, &expiresAt)
 	if err == sql.ErrNoRows {
 		return false, nil // token not found
 	}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// DIDBatchJob is a bulk DID creation request and the outcome of each of its keys
+type DIDBatchJob struct {
+	ID          int            `json:"id"`
+	AdminDID    string         `json:"admin_did"`
+	Status      string         `json:"status"`
+	Total       int            `json:"total"`
+	Created     int            `json:"created"`
+	Failed      int            `json:"failed"`
+	RequestedBy string         `json:"requested_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	FinishedAt  *time.Time     `json:"finished_at,omitempty"`
+	Items       []DIDBatchItem `json:"items,omitempty"`
+}
+
+// DIDBatchItem is one public key of a batch job. PublicKey is the key as submitted and
+// NormalizedKey the hex form forwarded to the node (empty if the key was rejected).
+type DIDBatchItem struct {
+	ID            int    `json:"-"`
+	JobID         int    `json:"-"`
+	Position      int    `json:"position"`
+	PublicKey     string `json:"public_key"`
+	NormalizedKey string `json:"normalized_key,omitempty"`
+	KeyType       string `json:"key_type,omitempty"`
+	Status        string `json:"status"`
+	DID           string `json:"did,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// DID batch job statuses
+const (
+	DIDBatchRunning   = "running"
+	DIDBatchCompleted = "completed"
+)
+
+// DID batch item statuses
+const (
+	DIDBatchItemPending  = "pending"
+	DIDBatchItemCreated  = "created"
+	DIDBatchItemFailed   = "failed"
+	DIDBatchItemRejected = "rejected"
+)
+
+func createDIDBatchTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS did_batch_jobs (
+		id SERIAL PRIMARY KEY,
+		admin_did TEXT NOT NULL,
+		status TEXT NOT NULL,
+		total INTEGER NOT NULL DEFAULT 0,
+		created INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		requested_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW(),
+		finished_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS did_batch_items (
+		id SERIAL PRIMARY KEY,
+		job_id INTEGER NOT NULL REFERENCES did_batch_jobs(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		public_key TEXT NOT NULL,
+		normalized_key TEXT NOT NULL DEFAULT '',
+		key_type TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		did TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS did_batch_items_job_idx ON did_batch_items (job_id, position);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create DID batch tables: %v", err)
+	}
+}
+
+// CreateDIDBatchJob inserts a running job and all of its items in one transaction,
+// filling in the generated IDs
+func CreateDIDBatchJob(job *DIDBatchJob) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	job.Status = DIDBatchRunning
+	job.Total = len(job.Items)
+	err = tx.QueryRow(`
+	INSERT INTO did_batch_jobs (admin_did, status, total, requested_by) VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`, job.AdminDID, job.Status, job.Total, job.RequestedBy).Scan(&job.ID, &job.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO did_batch_items (job_id, position, public_key, normalized_key, key_type, status, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range job.Items {
+		item := &job.Items[i]
+		item.JobID = job.ID
+		err := stmt.QueryRow(job.ID, item.Position, item.PublicKey, item.NormalizedKey, item.KeyType, item.Status, item.Error).Scan(&item.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FinishDIDBatchItem stores the outcome of creating one item's DID
+func FinishDIDBatchItem(item *DIDBatchItem) error {
+	_, err := DB.Exec(`UPDATE did_batch_items SET status = $2, did = $3, error = $4 WHERE id = $1`,
+		item.ID, item.Status, item.DID, item.Error)
+	return err
+}
+
+// FinishDIDBatchJob marks a job completed and stores its final counters
+func FinishDIDBatchJob(id int) error {
+	query := `
+	UPDATE did_batch_jobs SET
+		status = $2,
+		created = (SELECT COUNT(*) FROM did_batch_items WHERE job_id = $1 AND status = $3),
+		failed = (SELECT COUNT(*) FROM did_batch_items WHERE job_id = $1 AND status <> $3),
+		finished_at = $4
+	WHERE id = $1`
+	_, err := DB.Exec(query, id, DIDBatchCompleted, DIDBatchItemCreated, time.Now())
+	return err
+}
+
+// RunningDIDBatchJobs returns the IDs of jobs that have not finished, oldest first
+func RunningDIDBatchJobs() ([]int, error) {
+	rows, err := DB.Query(`SELECT id FROM did_batch_jobs WHERE status = $1 ORDER BY id`, DIDBatchRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetDIDBatchJob returns a job with its items in submission order, or nil if it does not exist
+func GetDIDBatchJob(id int) (*DIDBatchJob, error) {
+	var job DIDBatchJob
+	var finishedAt sql.NullTime
+	err := DB.QueryRow(`
+	SELECT id, admin_did, status, total, created, failed, requested_by, created_at, finished_at
+	FROM did_batch_jobs WHERE id = $1`, id).Scan(&job.ID, &job.AdminDID, &job.Status, &job.Total,
+		&job.Created, &job.Failed, &job.RequestedBy, &job.CreatedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	rows, err := DB.Query(`
+	SELECT id, job_id, position, public_key, normalized_key, key_type, status, did, error
+	FROM did_batch_items WHERE job_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	job.Items = []DIDBatchItem{}
+	for rows.Next() {
+		var item DIDBatchItem
+		err := rows.Scan(&item.ID, &item.JobID, &item.Position, &item.PublicKey, &item.NormalizedKey,
+			&item.KeyType, &item.Status, &item.DID, &item.Error)
+		if err != nil {
+			return nil, err
+		}
+		job.Items = append(job.Items, item)
+	}
+	return &job, rows.Err()
+}
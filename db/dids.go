@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPublicKeyUsed = errors.New("a DID has already been created for this public key")
+	ErrDIDClaimed    = errors.New("this DID has already been claimed by another account")
+)
+
+// DIDRecord is a DID created through Rubxy and the public key it was created from. CreatedBy is
+// the operator who requested it; Owner is the account of the holder, set once they prove they
+// hold its key, and is the only account the DID can log in as.
+type DIDRecord struct {
+	ID        int       `json:"-"`
+	DID       string    `json:"did"`
+	PublicKey string    `json:"public_key"`
+	KeyType   string    `json:"key_type"`
+	AdminDID  string    `json:"admin_did"`
+	CreatedBy string    `json:"created_by"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func createDIDsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS dids (
+		id SERIAL PRIMARY KEY,
+		did TEXT UNIQUE,
+		public_key TEXT UNIQUE NOT NULL,
+		key_type TEXT NOT NULL,
+		admin_did TEXT NOT NULL,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	ALTER TABLE dids ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE dids ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS dids_created_by_idx ON dids (created_by);
+	CREATE INDEX IF NOT EXISTS dids_owner_idx ON dids (owner);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'dids' table: %v", err)
+	}
+}
+
+// PublicKeyUsed returns true if a DID has already been created from the normalized public key
+func PublicKeyUsed(publicKey string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM dids WHERE public_key = $1)`, publicKey).Scan(&exists)
+	return exists, err
+}
+
+// ReserveDID claims a public key before its DID is created on the node, so two requests for the
+// same key cannot both reach the node. It returns ErrPublicKeyUsed if the key is already taken.
+// The reservation has no DID until CompleteDID; a reservation left by a crash keeps the key taken,
+// since the node may have created the DID.
+func ReserveDID(d *DIDRecord) error {
+	query := `
+	INSERT INTO dids (did, public_key, key_type, admin_did, created_by) VALUES (NULL, $1, $2, $3, $4)
+	RETURNING id, created_at`
+	err := DB.QueryRow(query, d.PublicKey, d.KeyType, d.AdminDID, d.CreatedBy).Scan(&d.ID, &d.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrPublicKeyUsed
+	}
+	return err
+}
+
+// CompleteDID records the DID the node created for a reservation; an empty DID is stored as NULL
+func CompleteDID(id int, did string) error {
+	_, err := DB.Exec(`UPDATE dids SET did = NULLIF($2, '') WHERE id = $1`, id, did)
+	return err
+}
+
+// ReleaseDID drops a reservation whose DID the node refused to create, freeing its public key
+func ReleaseDID(id int) error {
+	_, err := DB.Exec(`DELETE FROM dids WHERE id = $1 AND did IS NULL`, id)
+	return err
+}
+
+const didColumns = `id, COALESCE(did, ''), public_key, key_type, admin_did, created_by, owner, created_at`
+
+func scanDID(row interface{ Scan(...interface{}) error }) (*DIDRecord, error) {
+	var d DIDRecord
+	if err := row.Scan(&d.ID, &d.DID, &d.PublicKey, &d.KeyType, &d.AdminDID, &d.CreatedBy, &d.Owner, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetDID returns the registry entry for a DID, or nil if Rubxy did not create it
+func GetDID(did string) (*DIDRecord, error) {
+	d, err := scanDID(DB.QueryRow(`SELECT `+didColumns+` FROM dids WHERE did = $1`, did))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// ClaimDID makes owner the holder account of a DID. Claiming a DID again as its owner is a
+// no-op; it returns ErrDIDClaimed if another account owns it and sql.ErrNoRows if it is unknown.
+func ClaimDID(did, owner string) error {
+	var current string
+	err := DB.QueryRow(`
+	UPDATE dids SET owner = $2 WHERE did = $1 AND owner IN ('', $2)
+	RETURNING owner`, did, owner).Scan(&current)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	record, err := GetDID(did)
+	switch {
+	case err != nil:
+		return err
+	case record == nil:
+		return sql.ErrNoRows
+	}
+	return ErrDIDClaimed
+}
+
+// DIDFilter selects registry entries for ListDIDs. Search matches any part of the DID,
+// public key, admin DID or creating username; Account matches DIDs an account created or owns.
+// AfterID is the keyset cursor; results are newest first.
+type DIDFilter struct {
+	Search    string
+	AdminDID  string
+	CreatedBy string
+	Account   string
+	AfterID   int
+	Limit     int
+}
+
+// ListDIDs returns a page of registry entries matching the filter
+func ListDIDs(f DIDFilter) ([]DIDRecord, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Search != "" {
+		add("(did ILIKE $%[1]d OR public_key ILIKE $%[1]d OR admin_did ILIKE $%[1]d OR created_by ILIKE $%[1]d)", "%"+escapeLike(f.Search)+"%")
+	}
+	if f.AdminDID != "" {
+		add("admin_did = $%d", f.AdminDID)
+	}
+	if f.CreatedBy != "" {
+		add("created_by = $%d", f.CreatedBy)
+	}
+	if f.Account != "" {
+		add("(created_by = $%[1]d OR owner = $%[1]d)", f.Account)
+	}
+	if f.AfterID > 0 {
+		add("id < $%d", f.AfterID)
+	}
+
+	query := `SELECT ` + didColumns + ` FROM dids`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dids := []DIDRecord{}
+	for rows.Next() {
+		d, err := scanDID(rows)
+		if err != nil {
+			return nil, err
+		}
+		dids = append(dids, *d)
+	}
+	return dids, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
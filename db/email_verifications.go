@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Values of users.email_status. Users registered while email verification is required are
+// pending until they verify, and cannot log in until then.
+const (
+	EmailUnverified = "unverified"
+	EmailPending    = "pending"
+	EmailVerified   = "verified"
+)
+
+func createEmailVerificationsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS email_verifications (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		used_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS email_verifications_username_idx ON email_verifications (username);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'email_verifications' table: %v", err)
+	}
+}
+
+// SaveEmailVerification stores a new verification token for a user's email address. Only a
+// hash of the token is stored.
+func SaveEmailVerification(tokenHash, username, email string, expiresAt time.Time) error {
+	_, err := DB.Exec(`INSERT INTO email_verifications (token_hash, username, email, expires_at) VALUES ($1, $2, $3, $4)`,
+		tokenHash, username, email, expiresAt)
+	return err
+}
+
+// ConsumeEmailVerification uses up an unexpired verification token and marks the user's email
+// as verified, as long as it is still the address the token was sent to. It returns the
+// username, or "" if the token is not valid.
+func ConsumeEmailVerification(tokenHash string) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var username, email string
+	err = tx.QueryRow(`UPDATE email_verifications SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2 RETURNING username, email`, tokenHash, time.Now()).
+		Scan(&username, &email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	result, err := tx.Exec(`UPDATE users SET email_status = $3 WHERE username = $1 AND email = $2`, username, email, EmailVerified)
+	if err != nil {
+		return "", err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", nil
+	}
+	return username, tx.Commit()
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Account is a Rubxy login account, without its credentials
+type Account struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StreamPayouts calls fn for every payout created in [from, to), oldest first, without loading them all into memory
+func StreamPayouts(ctx context.Context, from, to *time.Time, fn func(*Payout) error) error {
+	query, args := timeRangeQuery(`SELECT `+payoutColumns+` FROM payouts`, from, to, "id")
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamActivities calls fn for every recorded activity created in [from, to), oldest first
+func StreamActivities(ctx context.Context, from, to *time.Time, fn func(*Activity) error) error {
+	query, args := timeRangeQuery(`SELECT activity_id, reward_points, admin_did, added_by, created_at FROM activities`, from, to, "created_at, activity_id")
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.ActivityID, &a.RewardPoints, &a.AdminDID, &a.AddedBy, &a.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(&a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// StreamAccounts calls fn for every account created in [from, to), oldest first
+func StreamAccounts(ctx context.Context, from, to *time.Time, fn func(*Account) error) error {
+	query, args := timeRangeQuery(`SELECT id, username, created_at FROM users`, from, to, "id")
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.Username, &a.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(&a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// timeRangeQuery appends an optional created_at range filter and ordering to a SELECT
+func timeRangeQuery(base string, from, to *time.Time, orderBy string) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+	if from != nil {
+		args = append(args, *from)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if to != nil {
+		args = append(args, *to)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	query := base
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	return query + ` ORDER BY ` + orderBy, args
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// InviteCode lets people register while registration is invite-only. Only a hash of the code
+// is stored; Prefix identifies it and is safe to show.
+type InviteCode struct {
+	ID        int        `json:"id"`
+	Prefix    string     `json:"prefix"`
+	CodeHash  string     `json:"-"`
+	Note      string     `json:"note,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func createInviteCodesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS invite_codes (
+		id SERIAL PRIMARY KEY,
+		prefix TEXT UNIQUE NOT NULL,
+		code_hash TEXT UNIQUE NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL,
+		max_uses INTEGER NOT NULL,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT NOW(),
+		revoked_at TIMESTAMP
+	);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'invite_codes' table: %v", err)
+	}
+}
+
+// SaveInviteCode inserts a new invite code and fills in its ID and creation time
+func SaveInviteCode(c *InviteCode) error {
+	query := `
+	INSERT INTO invite_codes (prefix, code_hash, note, created_by, max_uses, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	return DB.QueryRow(query, c.Prefix, c.CodeHash, c.Note, c.CreatedBy, c.MaxUses, c.ExpiresAt).Scan(&c.ID, &c.CreatedAt)
+}
+
+// ListInviteCodes returns every invite code, newest first
+func ListInviteCodes() ([]InviteCode, error) {
+	rows, err := DB.Query(`
+	SELECT id, prefix, code_hash, note, created_by, max_uses, uses, expires_at, created_at, revoked_at
+	FROM invite_codes ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []InviteCode{}
+	for rows.Next() {
+		var c InviteCode
+		var expiresAt, revokedAt sql.NullTime
+		err := rows.Scan(&c.ID, &c.Prefix, &c.CodeHash, &c.Note, &c.CreatedBy, &c.MaxUses, &c.Uses, &expiresAt, &c.CreatedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			c.ExpiresAt = &expiresAt.Time
+		}
+		if revokedAt.Valid {
+			c.RevokedAt = &revokedAt.Time
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+// RevokeInviteCode revokes an invite code; it returns false if there is no such unrevoked code
+func RevokeInviteCode(id int) (bool, error) {
+	result, err := DB.Exec(`UPDATE invite_codes SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, id, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RedeemInviteCode uses up one use of a valid invite code within tx and returns its ID, or 0
+// if the code is unknown, revoked, expired or used up. Rolling back tx gives the use back.
+func RedeemInviteCode(tx *sql.Tx, codeHash string) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+	UPDATE invite_codes SET uses = uses + 1
+	WHERE code_hash = $1 AND revoked_at IS NULL AND uses < max_uses AND (expires_at IS NULL OR expires_at > $2)
+	RETURNING id`, codeHash, time.Now()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
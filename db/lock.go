@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+)
+
+// Advisory lock keys used to elect a single instance for background work, and to
+// serialize appends to the audit log
+const (
+	PayoutSchedulerLockKey int64 = 7263001
+	JanitorLockKey         int64 = 7263002
+	AuditLogLockKey        int64 = 7263003
+	// DIDBatchLockClass is combined with a job ID, so each DID batch job has its own lock
+	DIDBatchLockClass int32 = 7263004
+)
+
+// WithAdvisoryLock runs fn only if the Postgres advisory lock identified by key
+// could be taken, so that a single Rubxy instance does the work when several run
+// against the same database. It reports whether fn was run.
+func WithAdvisoryLock(ctx context.Context, key int64, fn func() error) (bool, error) {
+	return withAdvisoryLock(ctx, `SELECT pg_try_advisory_lock($1)`, `SELECT pg_advisory_unlock($1)`, []interface{}{key}, fn)
+}
+
+// WithObjectLock is WithAdvisoryLock for one object of a class, such as a single DID batch job
+func WithObjectLock(ctx context.Context, class, id int32, fn func() error) (bool, error) {
+	return withAdvisoryLock(ctx, `SELECT pg_try_advisory_lock($1, $2)`, `SELECT pg_advisory_unlock($1, $2)`, []interface{}{class, id}, fn)
+}
+
+func withAdvisoryLock(ctx context.Context, lock, unlock string, args []interface{}, fn func() error) (bool, error) {
+	// Advisory locks belong to a session, so pin one connection for lock and unlock
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, lock, args...).Scan(&acquired); err != nil {
+		return false, err
+	}
+	if !acquired {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), unlock, args...)
+
+	return true, fn()
+}
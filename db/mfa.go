@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TOTPState is a user's TOTP enrollment. Secret is set but Enabled is false while an
+// enrollment is waiting to be confirmed.
+type TOTPState struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+func createRecoveryCodesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		username TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		used_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS recovery_codes_username_idx ON recovery_codes (username);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'recovery_codes' table: %v", err)
+	}
+}
+
+// GetTOTPState returns a user's TOTP enrollment, or nil if the user does not exist
+func GetTOTPState(username string) (*TOTPState, error) {
+	var s TOTPState
+	err := DB.QueryRow(`SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE username = $1`, username).
+		Scan(&s.Secret, &s.Enabled, &s.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SetPendingTOTPSecret starts a new enrollment; it returns false if TOTP is already enabled
+func SetPendingTOTPSecret(username, secret string) (bool, error) {
+	result, err := DB.Exec(`UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE username = $1 AND totp_enabled = FALSE`,
+		username, secret)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// EnableTOTP confirms a pending enrollment and replaces the user's recovery codes. step is
+// the time step of the code used to confirm, so it cannot be used again.
+func EnableTOTP(username string, step int64, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE users SET totp_enabled = TRUE, totp_last_step = $2
+		WHERE username = $1 AND totp_enabled = FALSE AND totp_secret <> ''`, username, step)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE username = $1`, username); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (username, code_hash) VALUES ($1, $2)`, username, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false if a code for this
+// or a later step was already used, which stops a code from being replayed.
+func UseTOTPStep(username string, step int64) (bool, error) {
+	result, err := DB.Exec(`UPDATE users SET totp_last_step = $2 WHERE username = $1 AND totp_last_step < $2`, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode marks an unused recovery code as used; it returns false if there is no such code
+func UseRecoveryCode(username, codeHash string) (bool, error) {
+	result, err := DB.Exec(`UPDATE recovery_codes SET used_at = $3 WHERE username = $1 AND code_hash = $2 AND used_at IS NULL`,
+		username, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
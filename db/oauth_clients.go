@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OAuthClient is a registered OAuth2 client. Tokens issued through the client_credentials
+// grant act as Owner; Scopes bounds the scopes any grant may request. RedirectURIs are the
+// exact URIs the authorization code flow may redirect to.
+type OAuthClient struct {
+	ClientID     string     `json:"client_id"`
+	Name         string     `json:"name"`
+	SecretHash   string     `json:"-"`
+	Scopes       []string   `json:"scopes"`
+	GrantTypes   []string   `json:"grant_types"`
+	RedirectURIs []string   `json:"redirect_uris"`
+	Owner        string     `json:"owner"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+func createOAuthClientsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS oauth_clients (
+		client_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		secret_hash TEXT NOT NULL,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		grant_types TEXT[] NOT NULL DEFAULT '{}',
+		owner TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		revoked_at TIMESTAMP
+	);
+	ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+	CREATE INDEX IF NOT EXISTS oauth_clients_owner_idx ON oauth_clients (owner);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'oauth_clients' table: %v", err)
+	}
+}
+
+// SaveOAuthClient inserts a new client and fills in its creation time
+func SaveOAuthClient(c *OAuthClient) error {
+	query := `
+	INSERT INTO oauth_clients (client_id, name, secret_hash, scopes, grant_types, redirect_uris, owner)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`
+	return DB.QueryRow(query, c.ClientID, c.Name, c.SecretHash, pq.Array(c.Scopes), pq.Array(c.GrantTypes),
+		pq.Array(c.RedirectURIs), c.Owner).Scan(&c.CreatedAt)
+}
+
+const oauthClientColumns = `client_id, name, secret_hash, scopes, grant_types, redirect_uris, owner, created_at, revoked_at`
+
+func scanOAuthClient(row interface{ Scan(...interface{}) error }) (*OAuthClient, error) {
+	var c OAuthClient
+	var revokedAt sql.NullTime
+	err := row.Scan(&c.ClientID, &c.Name, &c.SecretHash, pq.Array(&c.Scopes), pq.Array(&c.GrantTypes),
+		pq.Array(&c.RedirectURIs), &c.Owner, &c.CreatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		c.RevokedAt = &revokedAt.Time
+	}
+	return &c, nil
+}
+
+// GetOAuthClient returns a client, or nil if there is none with that ID
+func GetOAuthClient(clientID string) (*OAuthClient, error) {
+	c, err := scanOAuthClient(DB.QueryRow(`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE client_id = $1`, clientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// ListOAuthClients returns the clients owned by a user, newest first
+func ListOAuthClients(owner string) ([]OAuthClient, error) {
+	rows, err := DB.Query(`SELECT `+oauthClientColumns+` FROM oauth_clients WHERE owner = $1 ORDER BY created_at DESC`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *c)
+	}
+	return clients, rows.Err()
+}
+
+// RevokeOAuthClient revokes one of a user's clients; it returns false if the user has no such unrevoked client
+func RevokeOAuthClient(clientID, owner string) (bool, error) {
+	result, err := DB.Exec(`UPDATE oauth_clients SET revoked_at = $3 WHERE client_id = $1 AND owner = $2 AND revoked_at IS NULL`,
+		clientID, owner, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// OAuthCode is a single-use authorization code. Only a hash of the code is stored.
+type OAuthCode struct {
+	CodeHash      string
+	ClientID      string
+	Username      string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+}
+
+func createOAuthCodesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS oauth_codes (
+		code_hash TEXT PRIMARY KEY,
+		client_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		redirect_uri TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		nonce TEXT NOT NULL DEFAULT '',
+		code_challenge TEXT NOT NULL,
+		auth_time TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used BOOLEAN NOT NULL DEFAULT FALSE
+	);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'oauth_codes' table: %v", err)
+	}
+}
+
+// SaveOAuthCode stores a newly issued authorization code
+func SaveOAuthCode(c *OAuthCode) error {
+	query := `
+	INSERT INTO oauth_codes (code_hash, client_id, username, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := DB.Exec(query, c.CodeHash, c.ClientID, c.Username, c.RedirectURI, c.Scope, c.Nonce, c.CodeChallenge, c.AuthTime, c.ExpiresAt)
+	return err
+}
+
+// ConsumeOAuthCode marks an unused, unexpired code as used and returns it, or nil if there is
+// no such code. A code can only ever be consumed once.
+func ConsumeOAuthCode(codeHash string) (*OAuthCode, error) {
+	query := `
+	UPDATE oauth_codes SET used = TRUE
+	WHERE code_hash = $1 AND used = FALSE AND expires_at > $2
+	RETURNING code_hash, client_id, username, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at`
+	var c OAuthCode
+	err := DB.QueryRow(query, codeHash, time.Now()).Scan(&c.CodeHash, &c.ClientID, &c.Username, &c.RedirectURI,
+		&c.Scope, &c.Nonce, &c.CodeChallenge, &c.AuthTime, &c.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
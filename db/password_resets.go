@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+func createPasswordResetsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS password_resets (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		used_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS password_resets_username_idx ON password_resets (username);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'password_resets' table: %v", err)
+	}
+}
+
+// SavePasswordReset stores a new reset token. Only a hash of the token is stored.
+func SavePasswordReset(tokenHash, username string, expiresAt time.Time) error {
+	_, err := DB.Exec(`INSERT INTO password_resets (token_hash, username, expires_at) VALUES ($1, $2, $3)`,
+		tokenHash, username, expiresAt)
+	return err
+}
+
+// PasswordResetUsername returns the user an unused, unexpired reset token belongs to, or "" if there is no such token
+func PasswordResetUsername(tokenHash string) (string, error) {
+	var username string
+	err := DB.QueryRow(`SELECT username FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2`,
+		tokenHash, time.Now()).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return username, err
+}
+
+// ConsumePasswordReset marks an unused, unexpired reset token as used, along with every other
+// outstanding token of the same user, and returns the username. It returns "" if there is no
+// such token.
+func ConsumePasswordReset(tokenHash string) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var username string
+	err = tx.QueryRow(`UPDATE password_resets SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2 RETURNING username`, tokenHash, now).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(`UPDATE password_resets SET used_at = $2 WHERE username = $1 AND used_at IS NULL`, username, now); err != nil {
+		return "", err
+	}
+	return username, tx.Commit()
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Payout is Rubxy's record of a reward transfer forwarded to the external API
+type Payout struct {
+	ID            int       `json:"id"`
+	AdminDID      string    `json:"admin_did"`
+	UserDID       string    `json:"user_did"`
+	ActivityIDs   []string  `json:"activity_id"`
+	Status        string    `json:"status"`
+	HTTPStatus    int       `json:"http_status"`
+	RequestID     string    `json:"request_id,omitempty"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	BlockID       string    `json:"block_id,omitempty"`
+	Message       string    `json:"message"`
+	RequestedBy   string    `json:"requested_by"`
+	ScheduleID    *int      `json:"schedule_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Payout statuses
+const (
+	PayoutAccepted = "accepted"
+	PayoutFailed   = "failed"
+)
+
+// Activity is Rubxy's record of an activity successfully added through /admin/activity/add
+type Activity struct {
+	ActivityID   string    `json:"activity_id"`
+	RewardPoints int       `json:"reward_points"`
+	AdminDID     string    `json:"admin_did"`
+	AddedBy      string    `json:"added_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func createPayoutsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS payouts (
+		id SERIAL PRIMARY KEY,
+		admin_did TEXT NOT NULL,
+		user_did TEXT NOT NULL,
+		activity_ids TEXT[] NOT NULL,
+		status TEXT NOT NULL,
+		http_status INTEGER NOT NULL DEFAULT 0,
+		request_id TEXT NOT NULL DEFAULT '',
+		transaction_id TEXT NOT NULL DEFAULT '',
+		block_id TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL DEFAULT '',
+		requested_by TEXT NOT NULL DEFAULT '',
+		schedule_id INTEGER REFERENCES payout_schedules(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS payouts_user_did_idx ON payouts (user_did, created_at);
+	CREATE INDEX IF NOT EXISTS payouts_created_at_idx ON payouts (created_at);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'payouts' table: %v", err)
+	}
+}
+
+func createActivitiesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS activities (
+		activity_id TEXT PRIMARY KEY,
+		reward_points INTEGER NOT NULL,
+		admin_did TEXT NOT NULL,
+		added_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT NOW()
+	);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'activities' table: %v", err)
+	}
+}
+
+const payoutColumns = `id, admin_did, user_did, activity_ids, status, http_status, request_id, transaction_id, block_id, message, requested_by, schedule_id, created_at`
+
+func scanPayout(row interface{ Scan(...interface{}) error }) (*Payout, error) {
+	var p Payout
+	var scheduleID sql.NullInt64
+	err := row.Scan(&p.ID, &p.AdminDID, &p.UserDID, pq.Array(&p.ActivityIDs), &p.Status, &p.HTTPStatus,
+		&p.RequestID, &p.TransactionID, &p.BlockID, &p.Message, &p.RequestedBy, &scheduleID, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleID.Valid {
+		id := int(scheduleID.Int64)
+		p.ScheduleID = &id
+	}
+	return &p, nil
+}
+
+// SavePayout inserts a payout record and fills in its generated fields
+func SavePayout(p *Payout) error {
+	query := `
+	INSERT INTO payouts (admin_did, user_did, activity_ids, status, http_status, request_id, transaction_id, block_id, message, requested_by, schedule_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id, created_at`
+	return DB.QueryRow(query, p.AdminDID, p.UserDID, pq.Array(p.ActivityIDs), p.Status, p.HTTPStatus, p.RequestID,
+		p.TransactionID, p.BlockID, p.Message, p.RequestedBy, p.ScheduleID).Scan(&p.ID, &p.CreatedAt)
+}
+
+// AcceptedPayoutsBetween returns payouts accepted by the external API in [from, to), oldest first
+func AcceptedPayoutsBetween(from, to time.Time) ([]Payout, error) {
+	query := `SELECT ` + payoutColumns + ` FROM payouts
+	WHERE status = $1 AND created_at >= $2 AND created_at < $3
+	ORDER BY created_at, id`
+	return queryPayouts(query, PayoutAccepted, from, to)
+}
+
+// AcceptedPayoutsForUser returns every accepted payout made to a user DID
+func AcceptedPayoutsForUser(userDID string) ([]Payout, error) {
+	query := `SELECT ` + payoutColumns + ` FROM payouts WHERE status = $1 AND user_did = $2 ORDER BY created_at, id`
+	return queryPayouts(query, PayoutAccepted, userDID)
+}
+
+func queryPayouts(query string, args ...interface{}) ([]Payout, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := []Payout{}
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, *p)
+	}
+	return payouts, rows.Err()
+}
+
+// SaveActivity records an activity, updating its reward points if it was added before
+func SaveActivity(a *Activity) error {
+	query := `
+	INSERT INTO activities (activity_id, reward_points, admin_did, added_by) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (activity_id) DO UPDATE SET reward_points = EXCLUDED.reward_points, admin_did = EXCLUDED.admin_did`
+	_, err := DB.Exec(query, a.ActivityID, a.RewardPoints, a.AdminDID, a.AddedBy)
+	return err
+}
+
+// ActivityRewardPoints returns the reward points of the given activities; unknown activities are omitted
+func ActivityRewardPoints(activityIDs []string) (map[string]int, error) {
+	rows, err := DB.Query(`SELECT activity_id, reward_points FROM activities WHERE activity_id = ANY($1)`, pq.Array(activityIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make(map[string]int, len(activityIDs))
+	for rows.Next() {
+		var id string
+		var p int
+		if err := rows.Scan(&id, &p); err != nil {
+			return nil, err
+		}
+		points[id] = p
+	}
+	return points, rows.Err()
+}
+
+// PayoutFilter selects payouts for ListPayouts. Empty fields are not filtered on.
+// Results are ordered by id, which follows creation order, and AfterID is the keyset cursor.
+type PayoutFilter struct {
+	AdminDID string
+	// AdminDIDs, if not nil, limits payouts to those made under one of these admin DIDs
+	AdminDIDs  []string
+	UserDID    string
+	ActivityID string
+	Status     string
+	From       *time.Time
+	To         *time.Time
+	AfterID    int
+	Ascending  bool
+	Limit      int
+}
+
+// ListPayouts returns a page of payouts matching the filter
+func ListPayouts(f PayoutFilter) ([]Payout, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.AdminDID != "" {
+		add("admin_did = $%d", f.AdminDID)
+	}
+	if f.AdminDIDs != nil {
+		add("admin_did = ANY($%d)", pq.Array(f.AdminDIDs))
+	}
+	if f.UserDID != "" {
+		add("user_did = $%d", f.UserDID)
+	}
+	if f.ActivityID != "" {
+		add("$%d = ANY(activity_ids)", f.ActivityID)
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at < $%d", *f.To)
+	}
+
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+	if f.AfterID > 0 {
+		if f.Ascending {
+			add("id > $%d", f.AfterID)
+		} else {
+			add("id < $%d", f.AfterID)
+		}
+	}
+
+	query := `SELECT ` + payoutColumns + ` FROM payouts`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(` ORDER BY id %s LIMIT $%d`, order, len(args))
+
+	return queryPayouts(query, args...)
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ReconciliationReport summarizes one reconciliation run over a payout period
+type ReconciliationReport struct {
+	ID             int                      `json:"id"`
+	PeriodFrom     time.Time                `json:"from"`
+	PeriodTo       time.Time                `json:"to"`
+	Status         string                   `json:"status"`
+	PayoutsChecked int                      `json:"payouts_checked"`
+	MismatchCount  int                      `json:"mismatch_count"`
+	Error          string                   `json:"error,omitempty"`
+	RequestedBy    string                   `json:"requested_by"`
+	StartedAt      time.Time                `json:"started_at"`
+	FinishedAt     *time.Time               `json:"finished_at,omitempty"`
+	Mismatches     []ReconciliationMismatch `json:"mismatches,omitempty"`
+}
+
+// ReconciliationMismatch is a single discrepancy between Rubxy's records and the node
+type ReconciliationMismatch struct {
+	ID       int    `json:"id"`
+	ReportID int    `json:"report_id"`
+	PayoutID *int   `json:"payout_id,omitempty"`
+	UserDID  string `json:"user_did"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Reconciliation report statuses
+const (
+	ReconciliationRunning   = "running"
+	ReconciliationCompleted = "completed"
+	ReconciliationFailed    = "failed"
+)
+
+func createReconciliationTables() {
+	query := `
+	CREATE TABLE IF NOT EXISTS reconciliation_reports (
+		id SERIAL PRIMARY KEY,
+		period_from TIMESTAMP NOT NULL,
+		period_to TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		payouts_checked INTEGER NOT NULL DEFAULT 0,
+		mismatch_count INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		requested_by TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMP DEFAULT NOW(),
+		finished_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS reconciliation_mismatches (
+		id SERIAL PRIMARY KEY,
+		report_id INTEGER NOT NULL REFERENCES reconciliation_reports(id) ON DELETE CASCADE,
+		payout_id INTEGER REFERENCES payouts(id) ON DELETE SET NULL,
+		user_did TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		expected TEXT NOT NULL DEFAULT '',
+		actual TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS reconciliation_mismatches_report_idx ON reconciliation_mismatches (report_id);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create reconciliation tables: %v", err)
+	}
+}
+
+// CreateReconciliationReport inserts a running report and fills in its generated fields
+func CreateReconciliationReport(r *ReconciliationReport) error {
+	query := `
+	INSERT INTO reconciliation_reports (period_from, period_to, status, requested_by) VALUES ($1, $2, $3, $4)
+	RETURNING id, started_at`
+	r.Status = ReconciliationRunning
+	return DB.QueryRow(query, r.PeriodFrom, r.PeriodTo, r.Status, r.RequestedBy).Scan(&r.ID, &r.StartedAt)
+}
+
+// FinishReconciliationReport stores the final status and counters of a report
+func FinishReconciliationReport(id int, status string, payoutsChecked, mismatchCount int, errMsg string) error {
+	query := `
+	UPDATE reconciliation_reports
+	SET status = $2, payouts_checked = $3, mismatch_count = $4, error = $5, finished_at = $6
+	WHERE id = $1`
+	_, err := DB.Exec(query, id, status, payoutsChecked, mismatchCount, errMsg, time.Now())
+	return err
+}
+
+// SaveReconciliationMismatch records a discrepancy found by a report
+func SaveReconciliationMismatch(m *ReconciliationMismatch) error {
+	query := `
+	INSERT INTO reconciliation_mismatches (report_id, payout_id, user_did, kind, detail, expected, actual)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	return DB.QueryRow(query, m.ReportID, m.PayoutID, m.UserDID, m.Kind, m.Detail, m.Expected, m.Actual).Scan(&m.ID)
+}
+
+const reconciliationReportColumns = `id, period_from, period_to, status, payouts_checked, mismatch_count, error, requested_by, started_at, finished_at`
+
+func scanReconciliationReport(row interface{ Scan(...interface{}) error }) (*ReconciliationReport, error) {
+	var r ReconciliationReport
+	var finishedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.PeriodFrom, &r.PeriodTo, &r.Status, &r.PayoutsChecked, &r.MismatchCount,
+		&r.Error, &r.RequestedBy, &r.StartedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	return &r, nil
+}
+
+// GetReconciliationReport returns a report with its mismatches, or nil if it does not exist
+func GetReconciliationReport(id int) (*ReconciliationReport, error) {
+	row := DB.QueryRow(`SELECT `+reconciliationReportColumns+` FROM reconciliation_reports WHERE id = $1`, id)
+	report, err := scanReconciliationReport(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT id, report_id, payout_id, user_did, kind, detail, expected, actual
+	FROM reconciliation_mismatches WHERE report_id = $1 ORDER BY id`
+	rows, err := DB.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Mismatches = []ReconciliationMismatch{}
+	for rows.Next() {
+		var m ReconciliationMismatch
+		var payoutID sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.ReportID, &payoutID, &m.UserDID, &m.Kind, &m.Detail, &m.Expected, &m.Actual); err != nil {
+			return nil, err
+		}
+		if payoutID.Valid {
+			pid := int(payoutID.Int64)
+			m.PayoutID = &pid
+		}
+		report.Mismatches = append(report.Mismatches, m)
+	}
+	return report, rows.Err()
+}
+
+// ListReconciliationReports returns report summaries, newest first
+func ListReconciliationReports() ([]ReconciliationReport, error) {
+	rows, err := DB.Query(`SELECT ` + reconciliationReportColumns + ` FROM reconciliation_reports ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []ReconciliationReport{}
+	for rows.Next() {
+		r, err := scanReconciliationReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *r)
+	}
+	return reports, rows.Err()
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"log"
+	"time"
+)
+
+func createRevokedTokensTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		username TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'revoked_tokens' table: %v", err)
+	}
+}
+
+// RevokeAccessToken adds an access token's jti to the denylist until the token would have expired
+func RevokeAccessToken(jti, username string, expiresAt time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, username, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+	_, err := DB.Exec(query, jti, username, expiresAt)
+	return err
+}
+
+// IsAccessTokenRevoked returns true if the access token with this jti has been revoked
+func IsAccessTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`, jti).Scan(&revoked)
+	return revoked, err
+}
+
+// ActiveRevokedTokens returns the jti and expiry of every revoked access token that has not expired yet
+func ActiveRevokedTokens() (map[string]time.Time, error) {
+	rows, err := DB.Query(`SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > $1`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PayoutSchedule is a one-off (RunAt) or recurring (CronExpr) reward transfer
+type PayoutSchedule struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	AdminDID    string     `json:"admin_did"`
+	UserDID     string     `json:"user_did"`
+	ActivityIDs []string   `json:"activity_id"`
+	RunAt       *time.Time `json:"run_at,omitempty"`
+	CronExpr    string     `json:"cron,omitempty"`
+	NextRunAt   *time.Time `json:"next_run_at,omitempty"`
+	Enabled     bool       `json:"enabled"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// PayoutScheduleRun records the outcome of one execution of a payout schedule
+type PayoutScheduleRun struct {
+	ID           int        `json:"id"`
+	ScheduleID   int        `json:"schedule_id"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Status       string     `json:"status"`
+	Message      string     `json:"message"`
+	Result       string     `json:"result,omitempty"`
+}
+
+// Payout schedule run statuses
+const (
+	ScheduleRunRunning   = "running"
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+)
+
+func createPayoutSchedulesTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS payout_schedules (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		admin_did TEXT NOT NULL,
+		user_did TEXT NOT NULL,
+		activity_ids TEXT[] NOT NULL,
+		run_at TIMESTAMP,
+		cron_expr TEXT NOT NULL DEFAULT '',
+		next_run_at TIMESTAMP,
+		enabled BOOLEAN DEFAULT TRUE,
+		created_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT NOW(),
+		updated_at TIMESTAMP DEFAULT NOW()
+	);
+	CREATE INDEX IF NOT EXISTS payout_schedules_due_idx ON payout_schedules (next_run_at) WHERE enabled;`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'payout_schedules' table: %v", err)
+	}
+}
+
+func createPayoutScheduleRunsTable() {
+	query := `
+	CREATE TABLE IF NOT EXISTS payout_schedule_runs (
+		id SERIAL PRIMARY KEY,
+		schedule_id INTEGER NOT NULL REFERENCES payout_schedules(id) ON DELETE CASCADE,
+		scheduled_for TIMESTAMP NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		status TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS payout_schedule_runs_schedule_idx ON payout_schedule_runs (schedule_id, started_at);`
+	_, err := DB.Exec(query)
+	if err != nil {
+		log.Fatalf("Failed to create 'payout_schedule_runs' table: %v", err)
+	}
+}
+
+const payoutScheduleColumns = `id, name, admin_did, user_did, activity_ids, run_at, cron_expr, next_run_at, enabled, created_by, created_at, updated_at`
+
+func scanPayoutSchedule(row interface{ Scan(...interface{}) error }) (*PayoutSchedule, error) {
+	var s PayoutSchedule
+	var runAt, nextRunAt sql.NullTime
+	err := row.Scan(&s.ID, &s.Name, &s.AdminDID, &s.UserDID, pq.Array(&s.ActivityIDs), &runAt,
+		&s.CronExpr, &nextRunAt, &s.Enabled, &s.CreatedBy, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if runAt.Valid {
+		s.RunAt = &runAt.Time
+	}
+	if nextRunAt.Valid {
+		s.NextRunAt = &nextRunAt.Time
+	}
+	return &s, nil
+}
+
+// CreatePayoutSchedule inserts a schedule and fills in its generated fields
+func CreatePayoutSchedule(s *PayoutSchedule) error {
+	query := `
+	INSERT INTO payout_schedules (name, admin_did, user_did, activity_ids, run_at, cron_expr, next_run_at, created_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, enabled, created_at, updated_at`
+	return DB.QueryRow(query, s.Name, s.AdminDID, s.UserDID, pq.Array(s.ActivityIDs), s.RunAt, s.CronExpr,
+		s.NextRunAt, s.CreatedBy).Scan(&s.ID, &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// GetPayoutSchedule returns the schedule with the given ID, or nil if it does not exist
+func GetPayoutSchedule(id int) (*PayoutSchedule, error) {
+	row := DB.QueryRow(`SELECT `+payoutScheduleColumns+` FROM payout_schedules WHERE id = $1`, id)
+	s, err := scanPayoutSchedule(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// ListPayoutSchedules returns the schedules user created or that pay from an active admin DID
+// they own, newest first
+func ListPayoutSchedules(user string) ([]PayoutSchedule, error) {
+	query := `SELECT ` + payoutScheduleColumns + ` FROM payout_schedules
+	WHERE created_by = $1 OR admin_did IN (SELECT did FROM admin_dids WHERE owner = $1 AND status = $2)
+	ORDER BY id DESC`
+	rows, err := DB.Query(query, user, AdminDIDActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []PayoutSchedule{}
+	for rows.Next() {
+		s, err := scanPayoutSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, rows.Err()
+}
+
+// DisablePayoutSchedule stops a schedule from running again. It returns false if the schedule does not exist.
+func DisablePayoutSchedule(id int) (bool, error) {
+	res, err := DB.Exec(`UPDATE payout_schedules SET enabled = FALSE, next_run_at = NULL, updated_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DuePayoutSchedules returns enabled schedules whose next run is at or before now
+func DuePayoutSchedules(now time.Time) ([]PayoutSchedule, error) {
+	query := `SELECT ` + payoutScheduleColumns + ` FROM payout_schedules
+	WHERE enabled AND next_run_at IS NOT NULL AND next_run_at <= $1
+	ORDER BY next_run_at`
+	rows, err := DB.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []PayoutSchedule{}
+	for rows.Next() {
+		s, err := scanPayoutSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, rows.Err()
+}
+
+// AdvancePayoutSchedule moves a schedule to its next run, disabling it when nextRunAt is nil
+func AdvancePayoutSchedule(id int, nextRunAt *time.Time) error {
+	query := `UPDATE payout_schedules SET next_run_at = $2, enabled = ($2::timestamp IS NOT NULL), updated_at = NOW() WHERE id = $1`
+	_, err := DB.Exec(query, id, nextRunAt)
+	return err
+}
+
+// StartPayoutScheduleRun records that a schedule run has begun and returns its ID
+func StartPayoutScheduleRun(scheduleID int, scheduledFor time.Time) (int, error) {
+	var id int
+	query := `INSERT INTO payout_schedule_runs (schedule_id, scheduled_for, started_at, status) VALUES ($1, $2, $3, $4) RETURNING id`
+	err := DB.QueryRow(query, scheduleID, scheduledFor, time.Now(), ScheduleRunRunning).Scan(&id)
+	return id, err
+}
+
+// FailInterruptedPayoutScheduleRuns marks runs still recorded as running as failed. It must only
+// be called by the scheduler lock holder before it starts any run, when every such row was left
+// by an instance that stopped mid-run. It returns the number of runs marked.
+func FailInterruptedPayoutScheduleRuns() (int64, error) {
+	query := `UPDATE payout_schedule_runs SET finished_at = $1, status = $2, message = $3 WHERE status = $4`
+	res, err := DB.Exec(query, time.Now(), ScheduleRunFailed,
+		"Interrupted before finishing; check the payout history before retrying", ScheduleRunRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// FinishPayoutScheduleRun stores the final outcome of a schedule run
+func FinishPayoutScheduleRun(runID int, status, message, result string) error {
+	query := `UPDATE payout_schedule_runs SET finished_at = $2, status = $3, message = $4, result = $5 WHERE id = $1`
+	_, err := DB.Exec(query, runID, time.Now(), status, message, result)
+	return err
+}
+
+// ListPayoutScheduleRuns returns the run history of a schedule, newest first
+func ListPayoutScheduleRuns(scheduleID int) ([]PayoutScheduleRun, error) {
+	query := `
+	SELECT id, schedule_id, scheduled_for, started_at, finished_at, status, message, result
+	FROM payout_schedule_runs WHERE schedule_id = $1 ORDER BY started_at DESC`
+	rows, err := DB.Query(query, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []PayoutScheduleRun{}
+	for rows.Next() {
+		var run PayoutScheduleRun
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.ScheduledFor, &run.StartedAt, &finishedAt,
+			&run.Status, &run.Message, &run.Result); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
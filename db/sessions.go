@@ -0,0 +1,83 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session is a login, represented by its refresh token. IP is the address the session was
+// last used from.
+type Session struct {
+	ID         int64      `json:"id"`
+	Username   string     `json:"username"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+// TouchRefreshToken records that a refresh token was used from ip and returns its session ID
+func TouchRefreshToken(token, ip string) (int64, error) {
+	var id int64
+	err := DB.QueryRow(`UPDATE refresh_tokens SET last_used_at = $2, ip = $3 WHERE token_hash = $1 RETURNING id`,
+		hashRefreshToken(token), time.Now(), ip).Scan(&id)
+	return id, err
+}
+
+// ListSessions returns a user's sessions that are neither revoked nor expired, most recently used first
+func ListSessions(username string) ([]Session, error) {
+	rows, err := DB.Query(`
+	SELECT id, username, user_agent, ip, created_at, last_used_at, expires_at FROM refresh_tokens
+	WHERE username = $1 AND revoked = FALSE AND expires_at > $2
+	ORDER BY COALESCE(last_used_at, created_at) DESC`, username, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Username, &s.UserAgent, &s.IP, &s.CreatedAt, &lastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			s.LastUsedAt = &lastUsedAt.Time
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of a user's sessions; it returns false if the user has no such active session
+func RevokeSession(id int64, username string) (bool, error) {
+	result, err := DB.Exec(`UPDATE refresh_tokens SET revoked = TRUE WHERE id = $1 AND username = $2 AND revoked = FALSE`, id, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeUserSessions revokes every active session of a user except keep (0 keeps none) and
+// returns the IDs of the revoked sessions
+func RevokeUserSessions(username string, keep int64) ([]int64, error) {
+	rows, err := DB.Query(`UPDATE refresh_tokens SET revoked = TRUE
+		WHERE username = $1 AND id <> $2 AND revoked = FALSE AND expires_at > $3 RETURNING id`, username, keep, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,198 @@
+// Package didkey decodes, validates and normalizes the public keys Rubix DIDs are created from.
+package didkey
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/edwards25519"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+type KeyType string
+
+const (
+	Secp256k1 KeyType = "secp256k1"
+	Ed25519   KeyType = "ed25519"
+)
+
+// Multicodec prefixes (unsigned varints) used by multibase-encoded keys
+var (
+	secp256k1Multicodec = []byte{0xe7, 0x01}
+	ed25519Multicodec   = []byte{0xed, 0x01}
+)
+
+var (
+	ErrEmpty      = errors.New("public_key is required")
+	ErrEncoding   = errors.New("public_key must be hex, base64 or multibase (z, f, m or u) encoded")
+	ErrInvalidKey = errors.New("public_key is not a valid point on its curve")
+	// Re-encoding an uncompressed key would change the bytes the node registers, so only the
+	// compressed form is accepted
+	ErrUncompressedKey = errors.New("uncompressed secp256k1 public keys are not supported; send the 33 byte compressed key")
+)
+
+// PublicKey is a validated DID public key
+type PublicKey struct {
+	Type KeyType
+	// Bytes holds the key as submitted: the compressed SEC1 encoding for secp256k1 and the raw
+	// 32 bytes for Ed25519
+	Bytes []byte
+}
+
+// Hex returns the encoding forwarded to the node and stored in the registry: lowercase hex of Bytes
+func (k *PublicKey) Hex() string {
+	return hex.EncodeToString(k.Bytes)
+}
+
+// Parse decodes a hex, base64 or multibase public key and checks it is a valid compressed
+// secp256k1 (33 bytes) or Ed25519 (32 bytes) key
+func Parse(encoded string) (*PublicKey, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, ErrEmpty
+	}
+
+	// Encodings overlap (e.g. a hex string is also valid base64), so take the first
+	// decoding that yields a valid key and otherwise report why the first one failed
+	var firstErr error
+	for _, c := range decodings(encoded) {
+		key, err := parseRaw(c.raw, c.multibase)
+		if err == nil {
+			return key, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrEncoding
+	}
+	return nil, firstErr
+}
+
+func parseRaw(raw []byte, multibase bool) (*PublicKey, error) {
+	if multibase {
+		switch {
+		case hasPrefix(raw, secp256k1Multicodec):
+			return parseSecp256k1(raw[len(secp256k1Multicodec):])
+		case hasPrefix(raw, ed25519Multicodec):
+			return parseEd25519(raw[len(ed25519Multicodec):])
+		}
+		// Multibase without a multicodec prefix: fall through to length-based detection
+	}
+
+	switch {
+	case len(raw) == 33 && (raw[0] == 0x02 || raw[0] == 0x03):
+		return parseSecp256k1(raw)
+	case len(raw) == 65 && raw[0] == 0x04:
+		return nil, ErrUncompressedKey
+	case len(raw) == 32:
+		return parseEd25519(raw)
+	}
+	return nil, fmt.Errorf("public_key decodes to %d bytes; expected a 33 byte secp256k1 key or a 32 byte Ed25519 key", len(raw))
+}
+
+type decoding struct {
+	raw       []byte
+	multibase bool
+}
+
+// decodings returns every successful decoding of encoded, in order of preference: hex, multibase, base64
+func decodings(encoded string) []decoding {
+	var out []decoding
+	if raw, err := hex.DecodeString(encoded); err == nil {
+		out = append(out, decoding{raw: raw})
+	}
+	if raw, err := decodeMultibase(encoded); err == nil {
+		out = append(out, decoding{raw: raw, multibase: true})
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(encoded); err == nil {
+			out = append(out, decoding{raw: raw})
+			break
+		}
+	}
+	return out
+}
+
+func decodeMultibase(encoded string) ([]byte, error) {
+	if len(encoded) < 2 {
+		return nil, ErrEncoding
+	}
+	body := encoded[1:]
+	switch encoded[0] {
+	case 'z':
+		return decodeBase58(body)
+	case 'f', 'F':
+		return hex.DecodeString(strings.ToLower(body))
+	case 'm':
+		return base64.RawStdEncoding.DecodeString(body)
+	case 'u':
+		return base64.RawURLEncoding.DecodeString(body)
+	}
+	return nil, ErrEncoding
+}
+
+func parseSecp256k1(raw []byte) (*PublicKey, error) {
+	if len(raw) == 65 && raw[0] == 0x04 {
+		return nil, ErrUncompressedKey
+	}
+	if len(raw) != 33 {
+		return nil, fmt.Errorf("secp256k1 public_key must be 33 bytes, got %d", len(raw))
+	}
+	if _, err := secp256k1.ParsePubKey(raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return &PublicKey{Type: Secp256k1, Bytes: append([]byte(nil), raw...)}, nil
+}
+
+func parseEd25519(raw []byte) (*PublicKey, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("Ed25519 public_key must be 32 bytes, got %d", len(raw))
+	}
+	if _, err := new(edwards25519.Point).SetBytes(raw); err != nil {
+		return nil, ErrInvalidKey
+	}
+	return &PublicKey{Type: Ed25519, Bytes: append([]byte(nil), raw...)}, nil
+}
+
+func hasPrefix(b, prefix []byte) bool {
+	return len(b) > len(prefix) && string(b[:len(prefix)]) == string(prefix)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// decodeBase58 decodes the Bitcoin base58 alphabet used by multibase 'z'
+func decodeBase58(s string) ([]byte, error) {
+	if s == "" {
+		return nil, ErrEncoding
+	}
+
+	// Big-endian base-256 accumulator
+	out := []byte{}
+	for _, c := range []byte(s) {
+		carry := strings.IndexByte(base58Alphabet, c)
+		if carry < 0 {
+			return nil, ErrEncoding
+		}
+		for i := len(out) - 1; i >= 0; i-- {
+			carry += int(out[i]) * 58
+			out[i] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			out = append([]byte{byte(carry)}, out...)
+			carry >>= 8
+		}
+	}
+
+	// Each leading '1' encodes a leading zero byte
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), out...), nil
+}
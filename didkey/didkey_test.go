@@ -0,0 +1,72 @@
+package didkey
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	// Public key of RFC 8032 section 7.1 test 1
+	ed25519Hex = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+	// The secp256k1 generator point G, compressed
+	secp256k1Hex = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	// G uncompressed
+	secp256k1UncompressedHex = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+		"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoded  string
+		wantType KeyType
+		wantHex  string
+	}{
+		{"ed25519 hex", ed25519Hex, Ed25519, ed25519Hex},
+		{"ed25519 did:key multibase", "z6MktwupdmLXVVqTzCw4i46r4uGyosGXRnR3XjN4Zq7oMMsw", Ed25519, ed25519Hex},
+		{"ed25519 base64url", "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo", Ed25519, ed25519Hex},
+		{"ed25519 multibase hex", "f" + ed25519Hex, Ed25519, ed25519Hex},
+		{"secp256k1 hex", secp256k1Hex, Secp256k1, secp256k1Hex},
+		{"secp256k1 upper case hex", "0279BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", Secp256k1, secp256k1Hex},
+		{"secp256k1 did:key multibase", "zQ3shVc2UkAfJCdc1TR8E66J85h48P43r93q8jGPkPpjF9Ef9", Secp256k1, secp256k1Hex},
+		{"secp256k1 base64", "Anm+Zn753LusVaBilc6HCwcCm/zbLc4o2VnygVsW+BeY", Secp256k1, secp256k1Hex},
+		{"surrounding space", "  " + secp256k1Hex + "\n", Secp256k1, secp256k1Hex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Parse(tt.encoded)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.encoded, err)
+			}
+			if key.Type != tt.wantType || key.Hex() != tt.wantHex {
+				t.Errorf("Parse(%q) = %s %s, want %s %s", tt.encoded, key.Type, key.Hex(), tt.wantType, tt.wantHex)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"empty", "", ErrEmpty},
+		{"blank", "   ", ErrEmpty},
+		{"uncompressed secp256k1", secp256k1UncompressedHex, ErrUncompressedKey},
+		// x = 5 has no point on secp256k1
+		{"secp256k1 off curve", "020000000000000000000000000000000000000000000000000000000000000005", ErrInvalidKey},
+		{"not encoded", "not a key!", ErrEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.encoded); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.encoded, err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Parse("0102"); err == nil {
+		t.Error("Parse accepted a 2 byte key")
+	}
+}
@@ -0,0 +1,66 @@
+package didkey
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+var ErrSignatureEncoding = errors.New("signature must be hex or base64 encoded")
+
+// DecodeSignature decodes a hex or base64 encoded signature
+func DecodeSignature(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if raw, err := hex.DecodeString(encoded); err == nil && len(raw) > 0 {
+		return raw, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(encoded); err == nil && len(raw) > 0 {
+			return raw, nil
+		}
+	}
+	return nil, ErrSignatureEncoding
+}
+
+// Verify reports whether signature is a valid signature of message by k.
+// secp256k1 signatures are ECDSA over SHA-256(message), either DER or 64-byte r||s;
+// Ed25519 signatures are over message itself.
+func (k *PublicKey) Verify(message, signature []byte) bool {
+	switch k.Type {
+	case Ed25519:
+		return len(signature) == ed25519.SignatureSize && ed25519.Verify(ed25519.PublicKey(k.Bytes), message, signature)
+	case Secp256k1:
+		pubKey, err := secp256k1.ParsePubKey(k.Bytes)
+		if err != nil {
+			return false
+		}
+		sig, ok := parseECDSASignature(signature)
+		if !ok {
+			return false
+		}
+		hash := sha256.Sum256(message)
+		return sig.Verify(hash[:], pubKey)
+	}
+	return false
+}
+
+func parseECDSASignature(signature []byte) (*ecdsa.Signature, bool) {
+	if len(signature) == 64 {
+		var r, s secp256k1.ModNScalar
+		if r.SetByteSlice(signature[:32]) || s.SetByteSlice(signature[32:]) || r.IsZero() || s.IsZero() {
+			return nil, false
+		}
+		return ecdsa.NewSignature(&r, &s), true
+	}
+	sig, err := ecdsa.ParseDERSignature(signature)
+	if err != nil {
+		return nil, false
+	}
+	return sig, true
+}
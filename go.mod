@@ -5,10 +5,14 @@ go 1.23.0
 toolchain go1.23.9
 
 require (
+	filippo.io/edwards25519 v1.1.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
 	github.com/go-chi/chi/v5 v5.2.1
 	github.com/golang-jwt/jwt/v5 v5.2.2
+	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
+	github.com/robfig/cron/v3 v3.0.1
 	golang.org/x/crypto v0.38.0
 )
 
-require github.com/joho/godotenv v1.5.1 // indirect
+require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,92 @@
+// Package janitor deletes expired and revoked rows, such as old refresh tokens and used reset
+// tokens, so those tables do not grow forever. When several instances share a database only
+// the one holding the advisory lock does the work on each run.
+package janitor
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// Metrics are published under "janitor" at the expvar endpoint
+var (
+	metrics     = expvar.NewMap("janitor")
+	rowsDeleted = new(expvar.Map).Init()
+	runs        = new(expvar.Int)
+	failures    = new(expvar.Int)
+	lastRun     = new(expvar.String)
+	lastRunTook = new(expvar.Float)
+)
+
+func init() {
+	metrics.Set("rows_deleted", rowsDeleted)
+	metrics.Set("runs", runs)
+	metrics.Set("failures", failures)
+	metrics.Set("last_run", lastRun)
+	metrics.Set("last_run_seconds", lastRunTook)
+}
+
+// Run cleans up every cfg.JanitorInterval until ctx is cancelled. Rows are deleted once they
+// have been expired or revoked for cfg.JanitorRetention, cfg.JanitorBatchSize at a time.
+func Run(ctx context.Context, cfg *config.Config) {
+	logger.InfoLogger.Printf("[JANITOR] Started with interval %s and retention %s", cfg.JanitorInterval, cfg.JanitorRetention)
+
+	ticker := time.NewTicker(cfg.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.InfoLogger.Println("[JANITOR] Stopped")
+			return
+		case <-ticker.C:
+			// Instances that lose the lock simply skip this run
+			_, err := db.WithAdvisoryLock(ctx, db.JanitorLockKey, func() error {
+				return sweep(ctx, time.Now().Add(-cfg.JanitorRetention), cfg.JanitorBatchSize)
+			})
+			if err != nil {
+				failures.Add(1)
+				logger.ErrorLogger.Printf("[JANITOR] Run failed: %v", err)
+			}
+		}
+	}
+}
+
+// sweep purges every cleanup table in batches of batchSize, so no single statement holds
+// locks on many rows. A failing table is logged and the others are still cleaned.
+func sweep(ctx context.Context, cutoff time.Time, batchSize int) error {
+	started := time.Now()
+	var sweepErr error
+	for _, table := range db.CleanupTables() {
+		var total int64
+		for {
+			n, err := db.PurgeStaleRows(ctx, table, cutoff, batchSize)
+			if err != nil {
+				logger.ErrorLogger.Printf("[JANITOR] Failed to clean up %s: %v", table, err)
+				sweepErr = err
+				break
+			}
+			total += n
+			if n < int64(batchSize) || ctx.Err() != nil {
+				break
+			}
+		}
+		if total > 0 {
+			rowsDeleted.Add(table, total)
+			logger.InfoLogger.Printf("[JANITOR] Deleted %d rows from %s", total, table)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	runs.Add(1)
+	lastRun.Set(started.UTC().Format(time.RFC3339))
+	lastRunTook.Set(time.Since(started).Seconds())
+	return sweepErr
+}
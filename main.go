@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,11 +10,15 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"rubxy/auth"
+	"rubxy/clientip"
 	"rubxy/config"
 	"rubxy/db"
+	"rubxy/janitor"
 	"rubxy/logger"
 	"rubxy/middleware"
+	"rubxy/notify"
 	"rubxy/proxy"
+	"rubxy/users"
 )
 
 func main() {
@@ -22,9 +27,38 @@ func main() {
 	defer logger.LogFile.Close()
 	logger.InfoLogger.Println("Starting server...")
 
+	if err := users.Configure(cfg); err != nil {
+		log.Fatalf("Invalid password settings: %v", err)
+	}
+
 	db.Init(cfg.DatabaseURL)
 	defer db.DB.Close()
 
+	for did, owner := range cfg.BootstrapAdminDIDs {
+		if err := db.BootstrapAdminDID(did, owner); err != nil {
+			log.Fatalf("Failed to bootstrap admin DID %s: %v", did, err)
+		}
+	}
+	if err := users.PromoteAdmins(cfg.AdminUsers); err != nil {
+		log.Fatalf("Failed to assign admin roles: %v", err)
+	}
+
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up notifications: %v", err)
+	}
+	if notifier == nil {
+		if cfg.RequireEmailVerification {
+			log.Fatalf("REQUIRE_EMAIL_VERIFICATION needs a NOTIFIER to send verification tokens")
+		}
+		logger.InfoLogger.Println("No NOTIFIER configured; password reset and verification resend routes are disabled")
+	}
+
+	go proxy.RunPayoutScheduler(context.Background(), cfg.SchedulerInterval)
+	go auth.RunRevocationSync(context.Background(), cfg.RevocationRefreshInterval)
+	go janitor.Run(context.Background(), cfg)
+	go proxy.ResumeDIDBatches(context.Background())
+
 	r := chi.NewRouter()
 
 	// Clean paths (trim trailing spaces) - apply globally
@@ -32,33 +66,118 @@ func main() {
 
 	// Public routes
 	r.Post("/get-token", auth.HandleToken(cfg))
+	r.Post("/get-token/mfa", auth.HandleTokenMFA(cfg))
+	r.Post("/me/2fa/enroll", auth.HandleTOTPEnroll(cfg))
+	r.Post("/me/2fa/confirm", auth.HandleTOTPConfirm(cfg))
 	r.Post("/refresh-token", auth.HandleRefresh(cfg))
-	r.Post("/register", auth.HandleRegister())
-	r.Post("/logout", auth.HandleLogout())
+	r.Post("/register", auth.HandleRegister(cfg, notifier))
+	r.Post("/register/verify", auth.HandleVerifyEmail())
+	r.Post("/logout", auth.HandleLogout(cfg))
+	// Tokens for these routes can only reach their user through a notifier
+	if notifier != nil {
+		r.Post("/register/verify/resend", auth.HandleResendVerification(cfg, notifier))
+		r.Post("/password/reset/request", auth.HandlePasswordResetRequest(cfg, notifier))
+		r.Post("/password/reset/confirm", auth.HandlePasswordReset(cfg))
+	}
+	r.Post("/auth/did/challenge", auth.HandleDIDChallenge())
+	r.Post("/auth/did/verify", auth.HandleDIDVerify(cfg))
+	r.Post("/oauth/token", auth.HandleOAuthToken(cfg))
+	r.Post("/oauth/introspect", auth.HandleOAuthIntrospect(cfg))
+	r.Post("/oauth/revoke", auth.HandleOAuthRevoke(cfg))
+
+	// OpenID Connect provider
+	r.Get("/.well-known/openid-configuration", auth.HandleOIDCDiscovery(cfg))
+	r.Get("/oauth/jwks", auth.HandleOIDCJWKS())
+	r.Get("/oauth/authorize", auth.HandleOAuthAuthorize(cfg))
+	r.Post("/oauth/authorize", auth.HandleOAuthAuthorize(cfg))
+	r.Get("/oauth/userinfo", auth.HandleOIDCUserInfo(cfg))
+	r.Post("/oauth/userinfo", auth.HandleOIDCUserInfo(cfg))
 
-	// Protected admin routes - register /admin/payouts directly first
-	r.With(middleware.Authenticate(cfg)).Post("/admin/payouts", proxy.HandleAdminRewardTransfer)
-	r.With(middleware.Authenticate(cfg)).Get("/admin/payouts/status/{request_id}", proxy.HandleAdminPayoutStatus)
+	// Protected admin routes - register /admin/payouts directly first.
+	// Every token and API key carries scopes, and RequireScope checks the one each route needs.
+	scope := middleware.RequireScope
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopePayoutsWrite)).Post("/admin/payouts", proxy.HandleAdminRewardTransfer)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopePayoutsAdminRead)).Get("/admin/payouts", proxy.HandleListPayouts)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopePayoutsRead)).Get("/admin/payouts/status/{request_id}", proxy.HandleAdminPayoutStatus)
 
 	r.Route("/admin", func(admin chi.Router) {
 		admin.Use(middleware.Authenticate(cfg))
-		admin.Post("/activity/add", proxy.HandleAdminActivityAdd)
-		admin.Get("/activity/list", proxy.HandleGetAllActivities)
-		admin.Post("/user/add", proxy.HandleAdminAddUser)
+		admin.With(scope(auth.ScopeActivitiesWrite)).Post("/activity/add", proxy.HandleAdminActivityAdd)
+		admin.With(scope(auth.ScopeActivitiesRead)).Get("/activity/list", proxy.HandleGetAllActivities)
+		admin.With(scope(auth.ScopeAdminDIDsWrite)).Post("/user/add", proxy.HandleAdminAddUser)
+
+		admin.With(scope(auth.ScopePayoutsWrite)).Post("/payout-schedules", proxy.HandleCreatePayoutSchedule)
+		admin.With(scope(auth.ScopePayoutsAdminRead)).Get("/payout-schedules", proxy.HandleListPayoutSchedules)
+		admin.With(scope(auth.ScopePayoutsAdminRead)).Get("/payout-schedules/{id}", proxy.HandleGetPayoutSchedule)
+		admin.With(scope(auth.ScopePayoutsWrite)).Delete("/payout-schedules/{id}", proxy.HandleCancelPayoutSchedule)
+		admin.With(scope(auth.ScopePayoutsAdminRead)).Get("/payout-schedules/{id}/runs", proxy.HandleListPayoutScheduleRuns)
+
+		admin.With(scope(auth.ScopePayoutsWrite)).Post("/reconciliation", proxy.HandleStartReconciliation)
+		admin.With(scope(auth.ScopePayoutsAdminRead)).Get("/reconciliation", proxy.HandleListReconciliations)
+		admin.With(scope(auth.ScopePayoutsAdminRead)).Get("/reconciliation/{id}", proxy.HandleGetReconciliation)
+
+		admin.With(scope(auth.ScopeExport)).Get("/export/payouts", proxy.HandleExportPayouts)
+		admin.With(scope(auth.ScopeExport)).Get("/export/activities", proxy.HandleExportActivities)
+		admin.With(scope(auth.ScopeExport)).Get("/export/users", proxy.HandleExportUsers)
+
+		admin.With(scope(auth.ScopeDIDAdminRead)).Get("/dids", proxy.HandleListDIDs)
+		admin.With(scope(auth.ScopeAdminDIDsWrite)).Post("/dids/batch", proxy.HandleCreateDIDBatch)
+		admin.With(scope(auth.ScopeDIDAdminRead)).Get("/dids/batch/{id}", proxy.HandleGetDIDBatch)
+
+		admin.With(scope(auth.ScopeAdminManage)).Get("/admin-dids", proxy.HandleListAdminDIDs)
+		admin.With(scope(auth.ScopeAdminManage)).Delete("/admin-dids/{did}", proxy.HandleRevokeAdminDID)
+		admin.With(scope(auth.ScopeAdminManage)).Put("/admin-dids/{did}/owner", proxy.HandleSetAdminDIDOwner)
+
+		admin.With(scope(auth.ScopeAdminManage)).Post("/invites", proxy.HandleCreateInvite)
+		admin.With(scope(auth.ScopeAdminManage)).Get("/invites", proxy.HandleListInvites)
+		admin.With(scope(auth.ScopeAdminManage)).Delete("/invites/{id}", proxy.HandleRevokeInvite)
+
+		admin.With(scope(auth.ScopeAdminManage)).Get("/metrics", proxy.HandleMetrics)
+		admin.With(scope(auth.ScopeAdminManage)).Get("/audit", proxy.HandleListAuditEvents)
+
+		admin.With(scope(auth.ScopeAdminManage)).Get("/accounts", proxy.HandleListAccounts)
+		admin.With(scope(auth.ScopeAdminManage)).Get("/accounts/{username}", proxy.HandleGetAccount)
+		admin.With(scope(auth.ScopeAdminManage)).Delete("/accounts/{username}", proxy.HandleDeleteAccount(cfg))
+		admin.With(scope(auth.ScopeAdminManage)).Post("/accounts/{username}/disable", proxy.HandleDisableAccount(cfg))
+		admin.With(scope(auth.ScopeAdminManage)).Post("/accounts/{username}/enable", proxy.HandleEnableAccount)
+		admin.With(scope(auth.ScopeAdminManage)).Put("/accounts/{username}/role", proxy.HandleSetAccountRole)
+		admin.With(scope(auth.ScopeAdminManage)).Post("/accounts/{username}/password-reset", proxy.HandleForcePasswordReset(cfg, notifier))
+		admin.With(scope(auth.ScopeAdminManage)).Get("/accounts/{username}/sessions", proxy.HandleListUserSessions)
+		admin.With(scope(auth.ScopeAdminManage)).Delete("/accounts/{username}/sessions", proxy.HandleRevokeUserSessions(cfg))
+		admin.With(scope(auth.ScopeAdminManage)).Delete("/accounts/{username}/sessions/{id}", proxy.HandleRevokeUserSession(cfg))
+
+		// Only the user's own login session can manage credentials; the handlers reject API keys
+		// and tokens issued to OAuth2 clients
+		admin.With(scope(auth.ScopeCredentials)).Post("/api-keys", proxy.HandleCreateAPIKey)
+		admin.With(scope(auth.ScopeCredentials)).Get("/api-keys", proxy.HandleListAPIKeys)
+		admin.With(scope(auth.ScopeCredentials)).Delete("/api-keys/{id}", proxy.HandleRevokeAPIKey)
+		admin.With(scope(auth.ScopeCredentials)).Post("/oauth-clients", proxy.HandleCreateOAuthClient)
+		admin.With(scope(auth.ScopeCredentials)).Get("/oauth-clients", proxy.HandleListOAuthClients)
+		admin.With(scope(auth.ScopeCredentials)).Delete("/oauth-clients/{client_id}", proxy.HandleRevokeOAuthClient)
 	})
 
 	// Protected user routes
-	r.With(middleware.Authenticate(cfg)).Get("/users/{user_did}/payouts", proxy.HandleUserPayouts)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopePayoutsRead)).Get("/users/{user_did}/payouts", proxy.HandleUserPayouts)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopePayoutsRead)).Get("/users/{user_did}/payout-history", proxy.HandleUserPayoutHistory)
 
 	// Protected DID creation endpoint
-	r.With(middleware.Authenticate(cfg)).Post("/createdid", proxy.HandleCreateDID)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopeDIDCreate)).Post("/createdid", proxy.HandleCreateDID)
+
+	// Protected DID registry lookups
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopeDIDRead)).Get("/dids/{did}", proxy.HandleGetDID)
+	r.With(middleware.Authenticate(cfg), scope(auth.ScopeDIDRead)).Get("/me/dids", proxy.HandleListMyDIDs)
+	r.With(middleware.Authenticate(cfg)).Post("/me/dids/claim", proxy.HandleClaimDID)
+	r.With(middleware.Authenticate(cfg)).Post("/me/password", proxy.HandleChangePassword(cfg))
+	r.With(middleware.Authenticate(cfg)).Get("/me/sessions", proxy.HandleListMySessions)
+	r.With(middleware.Authenticate(cfg)).Post("/me/sessions/revoke-all", proxy.HandleRevokeAllMySessions(cfg))
+	r.With(middleware.Authenticate(cfg)).Delete("/me/sessions/{id}", proxy.HandleRevokeMySession(cfg))
 
 	// Protected routes
 	target := "http://localhost:20050"
 	proxyHandler := proxy.NewReverseProxy(target)
 	//r.With(middleware.Authenticate(cfg)).Handle("/*", proxyHandler)
 	r.Route("/api", func(api chi.Router) {
-		api.With(middleware.Authenticate(cfg)).Handle("/*", proxyHandler)
+		api.With(middleware.Authenticate(cfg), scope(auth.ScopeNodeProxy)).Handle("/*", proxyHandler)
 	})
 
 	// 404 handler – aggressively drop unknown/junk paths with minimal logging
@@ -72,18 +191,8 @@ func main() {
 		}
 
 		// For other unknown paths, log a compact 404 (no headers/body) so you can still debug
-		ip := r.RemoteAddr
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			ips := strings.Split(xff, ",")
-			if len(ips) > 0 {
-				ip = strings.TrimSpace(ips[0])
-			}
-		} else if xri := r.Header.Get("X-Real-Ip"); xri != "" {
-			ip = strings.TrimSpace(xri)
-		}
-
 		logMsg := fmt.Sprintf("[404] Method: %s, Path: %s, IP: %s",
-			r.Method, path, ip)
+			r.Method, path, clientip.FromRequest(r))
 		logger.InfoLogger.Printf(logMsg)
 		http.Error(w, "404 page not found", http.StatusNotFound)
 	})
@@ -91,16 +200,84 @@ func main() {
 	// Log registered routes
 	logger.InfoLogger.Println("Registered routes:")
 	logger.InfoLogger.Println("  POST /get-token")
+	logger.InfoLogger.Println("  POST /get-token/mfa")
+	logger.InfoLogger.Println("  POST /me/2fa/enroll")
+	logger.InfoLogger.Println("  POST /me/2fa/confirm")
 	logger.InfoLogger.Println("  POST /refresh-token")
 	logger.InfoLogger.Println("  POST /register")
+	logger.InfoLogger.Println("  POST /register/verify")
+	if notifier != nil {
+		logger.InfoLogger.Println("  POST /register/verify/resend")
+	}
 	logger.InfoLogger.Println("  POST /logout")
+	if notifier != nil {
+		logger.InfoLogger.Println("  POST /password/reset/request")
+		logger.InfoLogger.Println("  POST /password/reset/confirm")
+	}
+	logger.InfoLogger.Println("  POST /auth/did/challenge")
+	logger.InfoLogger.Println("  POST /auth/did/verify")
+	logger.InfoLogger.Println("  POST /oauth/token")
+	logger.InfoLogger.Println("  POST /oauth/introspect")
+	logger.InfoLogger.Println("  POST /oauth/revoke")
+	logger.InfoLogger.Println("  GET  /.well-known/openid-configuration")
+	logger.InfoLogger.Println("  GET  /oauth/jwks")
+	logger.InfoLogger.Println("  GET  /oauth/authorize")
+	logger.InfoLogger.Println("  POST /oauth/authorize")
+	logger.InfoLogger.Println("  GET  /oauth/userinfo")
 	logger.InfoLogger.Println("  POST /admin/activity/add (protected)")
 	logger.InfoLogger.Println("  POST /admin/payouts (protected)")
+	logger.InfoLogger.Println("  GET  /admin/payouts (protected)")
 	logger.InfoLogger.Println("  GET  /admin/payouts/status/{request_id} (protected)")
 	logger.InfoLogger.Println("  GET  /admin/activity/list (protected)")
 	logger.InfoLogger.Println("  POST /admin/user/add (protected)")
+	logger.InfoLogger.Println("  POST /admin/payout-schedules (protected)")
+	logger.InfoLogger.Println("  GET  /admin/payout-schedules (protected)")
+	logger.InfoLogger.Println("  GET  /admin/payout-schedules/{id} (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/payout-schedules/{id} (protected)")
+	logger.InfoLogger.Println("  GET  /admin/payout-schedules/{id}/runs (protected)")
+	logger.InfoLogger.Println("  POST /admin/reconciliation (protected)")
+	logger.InfoLogger.Println("  GET  /admin/reconciliation (protected)")
+	logger.InfoLogger.Println("  GET  /admin/reconciliation/{id} (protected)")
+	logger.InfoLogger.Println("  GET  /admin/export/payouts (protected)")
+	logger.InfoLogger.Println("  GET  /admin/export/activities (protected)")
+	logger.InfoLogger.Println("  GET  /admin/export/users (protected)")
+	logger.InfoLogger.Println("  GET  /admin/dids (protected)")
+	logger.InfoLogger.Println("  POST /admin/dids/batch (protected)")
+	logger.InfoLogger.Println("  GET  /admin/dids/batch/{id} (protected)")
+	logger.InfoLogger.Println("  GET  /admin/admin-dids (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/admin-dids/{did} (protected)")
+	logger.InfoLogger.Println("  PUT  /admin/admin-dids/{did}/owner (protected)")
+	logger.InfoLogger.Println("  POST /admin/invites (protected)")
+	logger.InfoLogger.Println("  GET  /admin/invites (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/invites/{id} (protected)")
+	logger.InfoLogger.Println("  GET  /admin/metrics (protected)")
+	logger.InfoLogger.Println("  GET  /admin/audit (protected)")
+	logger.InfoLogger.Println("  GET  /admin/accounts (protected)")
+	logger.InfoLogger.Println("  GET  /admin/accounts/{username} (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/accounts/{username} (protected)")
+	logger.InfoLogger.Println("  POST /admin/accounts/{username}/disable (protected)")
+	logger.InfoLogger.Println("  POST /admin/accounts/{username}/enable (protected)")
+	logger.InfoLogger.Println("  PUT  /admin/accounts/{username}/role (protected)")
+	logger.InfoLogger.Println("  POST /admin/accounts/{username}/password-reset (protected)")
+	logger.InfoLogger.Println("  GET  /admin/accounts/{username}/sessions (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/accounts/{username}/sessions (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/accounts/{username}/sessions/{id} (protected)")
+	logger.InfoLogger.Println("  POST /admin/api-keys (protected)")
+	logger.InfoLogger.Println("  GET  /admin/api-keys (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/api-keys/{id} (protected)")
+	logger.InfoLogger.Println("  POST /admin/oauth-clients (protected)")
+	logger.InfoLogger.Println("  GET  /admin/oauth-clients (protected)")
+	logger.InfoLogger.Println("  DELETE /admin/oauth-clients/{client_id} (protected)")
 	logger.InfoLogger.Println("  GET  /users/{user_did}/payouts (protected)")
+	logger.InfoLogger.Println("  GET  /users/{user_did}/payout-history (protected)")
 	logger.InfoLogger.Println("  POST /createdid (protected)")
+	logger.InfoLogger.Println("  GET  /dids/{did} (protected)")
+	logger.InfoLogger.Println("  GET  /me/dids (protected)")
+	logger.InfoLogger.Println("  POST /me/dids/claim (protected)")
+	logger.InfoLogger.Println("  POST /me/password (protected)")
+	logger.InfoLogger.Println("  GET  /me/sessions (protected)")
+	logger.InfoLogger.Println("  POST /me/sessions/revoke-all (protected)")
+	logger.InfoLogger.Println("  DELETE /me/sessions/{id} (protected)")
 	logger.InfoLogger.Println("  *    /api/* (protected, proxied)")
 
 	log.Println("Registered routes:")
@@ -8,11 +8,18 @@ import (
 	"rubxy/auth"
 	"rubxy/config"
 	"rubxy/logger"
+	"rubxy/users"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey    = contextKey("user")
+	scopesContextKey  = contextKey("scopes")
+	apiKeyContextKey  = contextKey("api_key")
+	sessionContextKey = contextKey("session")
+	clientContextKey  = contextKey("oauth_client")
+)
 
 func Authenticate(cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -20,6 +27,11 @@ func Authenticate(cfg *config.Config) func(http.Handler) http.Handler {
 			logger.InfoLogger.Printf("[AUTH MIDDLEWARE] Checking authentication - Method: %s, Path: %s, RemoteAddr: %s",
 				r.Method, r.URL.Path, r.RemoteAddr)
 
+			if apiKey := apiKeyFromRequest(r); apiKey != "" {
+				authenticateAPIKey(w, r, next, apiKey)
+				return
+			}
+
 			authHeader := r.Header.Get("Authorization")
 			hasAuthHeader := authHeader != ""
 			token := strings.TrimPrefix(authHeader, "Bearer ")
@@ -35,18 +47,104 @@ func Authenticate(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			if rejectDisabledUser(w, r, claims.Username) {
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), userContextKey, claims.Username)
+			if scopes := claims.Scopes(); scopes != nil {
+				ctx = context.WithValue(ctx, scopesContextKey, scopes)
+			}
+			if claims.SessionID != 0 {
+				ctx = context.WithValue(ctx, sessionContextKey, claims.SessionID)
+			}
+			if claims.ClientID != "" {
+				ctx = context.WithValue(ctx, clientContextKey, claims.ClientID)
+			}
 			logger.InfoLogger.Printf("[AUTH MIDDLEWARE] Authenticated request by user: %s, Path: %s", claims.Username, r.URL.Path)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// apiKeyFromRequest returns the API key sent as X-API-Key or "Authorization: ApiKey <key>", if any
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// authenticateAPIKey serves the request as the user the API key belongs to, limited to the key's scopes
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := auth.ValidateAPIKey(key)
+	if err != nil {
+		logger.InfoLogger.Printf("[AUTH MIDDLEWARE] Rejected API key - Path: %s, Error: %v", r.URL.Path, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if rejectDisabledUser(w, r, apiKey.Username) {
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, apiKey.Username)
+	ctx = context.WithValue(ctx, scopesContextKey, apiKey.Scopes)
+	ctx = context.WithValue(ctx, apiKeyContextKey, apiKey.Prefix)
+	logger.InfoLogger.Printf("[AUTH MIDDLEWARE] Authenticated request by user: %s (API key %s), Path: %s",
+		apiKey.Username, apiKey.Prefix, r.URL.Path)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// rejectDisabledUser writes a 401 and returns true if the credential's user has been disabled,
+// so tokens and API keys issued before an account was disabled stop working at once
+func rejectDisabledUser(w http.ResponseWriter, r *http.Request, username string) bool {
+	disabled, err := users.IsDisabled(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[AUTH MIDDLEWARE] Failed to check whether %s is disabled: %v", username, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	if disabled {
+		logger.InfoLogger.Printf("[AUTH MIDDLEWARE] Rejected request by disabled user %s - Path: %s", username, r.URL.Path)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return true
+	}
+	return false
+}
+
 func GetUserFromContext(r *http.Request) string {
 	user, _ := r.Context().Value(userContextKey).(string)
 	return user
 }
 
+// GetScopesFromContext returns the scopes the request's credential was limited to
+// and whether it was limited at all
+func GetScopesFromContext(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value(scopesContextKey).([]string)
+	return scopes, ok
+}
+
+// GetSessionIDFromContext returns the session the request's access token belongs to, or 0
+func GetSessionIDFromContext(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionContextKey).(int64)
+	return id
+}
+
+// IsAPIKeyRequest reports whether the request was authenticated with an API key
+func IsAPIKeyRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(apiKeyContextKey).(string)
+	return ok
+}
+
+// IsOAuthClientRequest reports whether the request was authenticated with a token issued to an OAuth2 client
+func IsOAuthClientRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(clientContextKey).(string)
+	return ok
+}
+
 // CleanPath trims trailing spaces and normalizes the request path
 func CleanPath(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -55,3 +153,28 @@ func CleanPath(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireScope rejects requests whose token or API key was not granted scope. Tokens issued
+// before logins were scoped carry no scopes and are held to what the user's role allows now.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, limited := GetScopesFromContext(r)
+			if !limited {
+				var err error
+				scopes, err = auth.UserScopes(GetUserFromContext(r))
+				if err != nil {
+					logger.ErrorLogger.Printf("[AUTH MIDDLEWARE] Failed to look up scopes for %s: %v", GetUserFromContext(r), err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			}
+			if !auth.HasScope(scopes, scope) {
+				logger.InfoLogger.Printf("[AUTH MIDDLEWARE] User %s lacks scope %s - Path: %s", GetUserFromContext(r), scope, r.URL.Path)
+				http.Error(w, "Forbidden: missing scope "+scope, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
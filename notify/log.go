@@ -0,0 +1,16 @@
+package notify
+
+import (
+	"context"
+
+	"rubxy/logger"
+)
+
+// LogNotifier records that a message was sent without delivering it. Only the envelope is
+// logged: bodies and data carry reset and verification tokens, which must not reach the log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, msg Message) error {
+	logger.InfoLogger.Printf("[NOTIFY] %s for %s <%s>: %s (not delivered)", msg.Kind, msg.Username, msg.To, msg.Subject)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package notify delivers messages to users, such as password reset links, through a
+// configurable channel.
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"rubxy/config"
+)
+
+// Message kinds, so webhook receivers can route or template notifications themselves
+const (
+	KindPasswordReset     = "password_reset"
+	KindEmailVerification = "email_verification"
+)
+
+// Message is a notification for one user. Data carries the values Body was built from, such
+// as the reset token, for channels that render their own text.
+type Message struct {
+	Kind     string            `json:"kind"`
+	Username string            `json:"username"`
+	To       string            `json:"to,omitempty"`
+	Subject  string            `json:"subject"`
+	Body     string            `json:"body"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New returns the notifier selected by cfg.Notifier, or nil if none is configured
+func New(cfg *config.Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case "":
+		return nil, nil
+	case "log":
+		return LogNotifier{}, nil
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.SMTPFrom == "" {
+			return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM are required for the smtp notifier")
+		}
+		return &SMTPNotifier{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		}, nil
+	case "webhook":
+		if cfg.NotifyWebhookURL == "" {
+			return nil, fmt.Errorf("NOTIFY_WEBHOOK_URL is required for the webhook notifier")
+		}
+		return NewWebhookNotifier(cfg.NotifyWebhookURL, cfg.NotifyWebhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q, expected log, smtp or webhook", cfg.Notifier)
+	}
+}
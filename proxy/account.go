@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"rubxy/auth"
+	"rubxy/config"
+	"rubxy/logger"
+	"rubxy/middleware"
+	"rubxy/users"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// rejectDelegatedRequest stops API keys and tokens issued to OAuth2 clients from managing the
+// account or its credentials, so a delegated token cannot turn into a long-lived one; only the
+// user's own login session may
+func rejectDelegatedRequest(w http.ResponseWriter, r *http.Request) bool {
+	if rejectAPIKeyRequest(w, r) {
+		return true
+	}
+	if middleware.IsOAuthClientRequest(r) {
+		sendErrorResponse(w, http.StatusForbidden, "This action needs your own login session, not an application's token")
+		return true
+	}
+	return false
+}
+
+// HandleChangePassword changes the caller's password after checking the current one, and
+// ends all their other sessions; the session the request was made with is kept
+func HandleChangePassword(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectDelegatedRequest(w, r) {
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.CurrentPassword == "" || req.NewPassword == "" {
+			sendErrorResponse(w, http.StatusBadRequest, "current_password and new_password are required")
+			return
+		}
+		if req.NewPassword == req.CurrentPassword {
+			sendErrorResponse(w, http.StatusBadRequest, "new_password must differ from current_password")
+			return
+		}
+
+		username := middleware.GetUserFromContext(r)
+		if !users.Authenticate(username, req.CurrentPassword) {
+			logger.InfoLogger.Printf("[PASSWORD] Wrong current password for %s", username)
+			sendErrorResponse(w, http.StatusUnauthorized, "Current password is incorrect")
+			return
+		}
+
+		if err := users.SetPassword(username, req.NewPassword); err != nil {
+			var policyErr *users.PolicyError
+			if errors.As(err, &policyErr) {
+				sendErrorResponse(w, http.StatusBadRequest, policyErr.Error())
+				return
+			}
+			logger.ErrorLogger.Printf("[PASSWORD] Failed to change password for %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to change password")
+			return
+		}
+		revoked, err := auth.RevokeUserSessions(cfg, username, middleware.GetSessionIDFromContext(r))
+		if err != nil {
+			logger.ErrorLogger.Printf("[PASSWORD] Failed to revoke other sessions of %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Password changed, but other sessions could not be revoked")
+			return
+		}
+		logger.InfoLogger.Printf("[PASSWORD] Password changed for %s, %d other sessions revoked", username, len(revoked))
+
+		sendSuccessResponse(w, http.StatusOK, "Password changed", map[string]int{"revoked_sessions": len(revoked)})
+	}
+}
@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"rubxy/audit"
+	"rubxy/auth"
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+	"rubxy/notify"
+	"rubxy/users"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// accountParam returns the {username} URL parameter, writing a 404 if there is no such account
+func accountParam(w http.ResponseWriter, r *http.Request) (*db.AccountDetails, bool) {
+	username := chi.URLParam(r, "username")
+	account, err := db.GetAccount(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ACCOUNTS] Failed to get account %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to get account")
+		return nil, false
+	}
+	if account == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Account not found")
+		return nil, false
+	}
+	return account, true
+}
+
+// rejectSelf writes a 400 and returns true if an admin is about to act on their own account,
+// which could lock the last admin out
+func rejectSelf(w http.ResponseWriter, r *http.Request, username, action string) bool {
+	if username == middleware.GetUserFromContext(r) {
+		sendErrorResponse(w, http.StatusBadRequest, "You cannot "+action+" your own account")
+		return true
+	}
+	return false
+}
+
+// auditAccountAction records an admin's successful action on an account
+func auditAccountAction(r *http.Request, action, username string, payload interface{}) {
+	audit.Record(r, audit.Event{
+		Action:  action,
+		Actor:   middleware.GetUserFromContext(r),
+		Target:  username,
+		Outcome: audit.OutcomeSuccess,
+		Payload: payload,
+	})
+}
+
+// HandleListAccounts lists accounts, newest first, optionally filtered by q (part of the
+// username or email), role and disabled
+func HandleListAccounts(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.AccountFilter{Search: query.Get("q"), Role: query.Get("role")}
+	if value := query.Get("disabled"); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "disabled must be true or false")
+			return
+		}
+		filter.Disabled = &disabled
+	}
+	var err error
+	if filter.Limit, err = parseLimit(r); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.AfterID, err = decodeCursor(query.Get("cursor")); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	accounts, err := db.ListAccounts(filter)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ACCOUNTS] Failed to list accounts: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list accounts")
+		return
+	}
+
+	page := Page{Items: accounts}
+	if len(accounts) == filter.Limit {
+		page.NextCursor = encodeCursor(accounts[len(accounts)-1].ID)
+	}
+	sendSuccessResponse(w, http.StatusOK, "Accounts fetched successfully", page)
+}
+
+// HandleGetAccount returns one account
+func HandleGetAccount(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+	account, ok := accountParam(w, r)
+	if !ok {
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Account fetched successfully", account)
+}
+
+// HandleDisableAccount disables an account and ends all of its sessions. A disabled user cannot
+// log in, and their remaining tokens and API keys are refused until the account is enabled again.
+func HandleDisableAccount(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminRole(w, r) {
+			return
+		}
+		username := chi.URLParam(r, "username")
+		if rejectSelf(w, r, username, "disable") {
+			return
+		}
+
+		found, err := users.SetDisabled(username, true)
+		if err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to disable %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to disable account")
+			return
+		}
+		if !found {
+			sendErrorResponse(w, http.StatusNotFound, "Account not found")
+			return
+		}
+		revoked, err := auth.RevokeUserSessions(cfg, username, 0)
+		if err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to revoke sessions of %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Account disabled, but its sessions could not be revoked")
+			return
+		}
+
+		auditAccountAction(r, audit.ActionAccountDisable, username, nil)
+		logger.InfoLogger.Printf("[ACCOUNTS] User %s disabled %s, %d sessions revoked",
+			middleware.GetUserFromContext(r), username, len(revoked))
+		sendSuccessResponse(w, http.StatusOK, "Account disabled", map[string]int{"revoked_sessions": len(revoked)})
+	}
+}
+
+// HandleEnableAccount re-enables a disabled account
+func HandleEnableAccount(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	found, err := users.SetDisabled(username, false)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ACCOUNTS] Failed to enable %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to enable account")
+		return
+	}
+	if !found {
+		sendErrorResponse(w, http.StatusNotFound, "Account not found")
+		return
+	}
+
+	auditAccountAction(r, audit.ActionAccountEnable, username, nil)
+	logger.InfoLogger.Printf("[ACCOUNTS] User %s enabled %s", middleware.GetUserFromContext(r), username)
+	sendSuccessResponse(w, http.StatusOK, "Account enabled", nil)
+}
+
+// HandleSetAccountRole assigns the user or admin role. Users listed in ADMIN_USERS are promoted
+// again at the next startup.
+func HandleSetAccountRole(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	username := chi.URLParam(r, "username")
+	if req.Role != users.RoleAdmin && rejectSelf(w, r, username, "demote") {
+		return
+	}
+
+	found, err := users.SetRole(username, req.Role)
+	if errors.Is(err, users.ErrInvalidRole) {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("[ACCOUNTS] Failed to set role of %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to set role")
+		return
+	}
+	if !found {
+		sendErrorResponse(w, http.StatusNotFound, "Account not found")
+		return
+	}
+
+	auditAccountAction(r, audit.ActionRoleChange, username, req)
+	logger.InfoLogger.Printf("[ACCOUNTS] User %s set the role of %s to %s", middleware.GetUserFromContext(r), username, req.Role)
+	sendSuccessResponse(w, http.StatusOK, "Role updated", map[string]string{"role": req.Role})
+}
+
+// HandleForcePasswordReset locks an account's current password, ends its sessions and sends the
+// user a password reset link; they cannot log in again until they choose a new password
+func HandleForcePasswordReset(cfg *config.Config, notifier notify.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminRole(w, r) {
+			return
+		}
+		account, ok := accountParam(w, r)
+		if !ok {
+			return
+		}
+
+		if _, err := users.LockPassword(account.Username); err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to lock the password of %s: %v", account.Username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to reset password")
+			return
+		}
+		revoked, err := auth.RevokeUserSessions(cfg, account.Username, 0)
+		if err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to revoke sessions of %s: %v", account.Username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Password locked, but sessions could not be revoked")
+			return
+		}
+		message := "Password reset; a reset link is being sent to the user"
+		emailSent := false
+		switch {
+		case account.Email == "":
+			message = "Password reset; the account has no email address, so no reset link could be sent"
+		case notifier == nil:
+			message = "Password reset; no NOTIFIER is configured, so no reset link could be sent"
+		case !auth.QueuePasswordReset(cfg, notifier, account.Username):
+			message = "Password reset; too many reset links are being sent, so none was sent to the user"
+		default:
+			emailSent = true
+		}
+
+		auditAccountAction(r, audit.ActionPasswordReset, account.Username, nil)
+		logger.InfoLogger.Printf("[ACCOUNTS] User %s forced a password reset for %s, %d sessions revoked",
+			middleware.GetUserFromContext(r), account.Username, len(revoked))
+		sendSuccessResponse(w, http.StatusOK, message, map[string]interface{}{
+			"revoked_sessions": len(revoked),
+			"email_sent":       emailSent,
+		})
+	}
+}
+
+// HandleDeleteAccount deletes an account and revokes everything it could still authenticate with
+func HandleDeleteAccount(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminRole(w, r) {
+			return
+		}
+		username := chi.URLParam(r, "username")
+		if rejectSelf(w, r, username, "delete") {
+			return
+		}
+
+		admin := middleware.GetUserFromContext(r)
+		sessions, found, err := users.Delete(username, admin)
+		if err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to delete %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete account")
+			return
+		}
+		if !found {
+			sendErrorResponse(w, http.StatusNotFound, "Account not found")
+			return
+		}
+		if err := auth.RevokeSessionAccess(cfg, username, sessions...); err != nil {
+			logger.ErrorLogger.Printf("[ACCOUNTS] Failed to revoke access tokens of %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Account deleted, but its access tokens could not be revoked")
+			return
+		}
+
+		auditAccountAction(r, audit.ActionAccountDelete, username, nil)
+		logger.InfoLogger.Printf("[ACCOUNTS] User %s deleted %s, %d sessions revoked", admin, username, len(sessions))
+		sendSuccessResponse(w, http.StatusOK, "Account deleted", map[string]int{"revoked_sessions": len(sessions)})
+	}
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+	"rubxy/users"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errUnknownAdminDID  = errors.New("admin_did is not a known admin DID")
+	errRevokedAdminDID  = errors.New("admin_did has been revoked")
+	errAdminDIDNotOwned = errors.New("admin_did does not belong to the calling user")
+)
+
+// checkAdminDID returns nil if did is an active admin DID owned by user
+func checkAdminDID(did, user string) error {
+	admin, err := db.GetAdminDID(did)
+	if err != nil {
+		return err
+	}
+	switch {
+	case admin == nil:
+		return errUnknownAdminDID
+	case admin.Status != db.AdminDIDActive:
+		return errRevokedAdminDID
+	case admin.Owner != user:
+		return errAdminDIDNotOwned
+	}
+	return nil
+}
+
+// resolveAdminDID returns the admin DID a request acts as. An empty did is filled in with the
+// caller's only active admin DID; any other did must be an active admin DID the caller owns.
+// On failure it writes an error response and returns false.
+func resolveAdminDID(w http.ResponseWriter, r *http.Request, did string) (string, bool) {
+	user := middleware.GetUserFromContext(r)
+	if did == "" {
+		owned, err := db.ListAdminDIDs(user, db.AdminDIDActive)
+		if err != nil {
+			logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to list admin DIDs of user %s: %v", user, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to look up admin_did")
+			return "", false
+		}
+		switch len(owned) {
+		case 0:
+			sendErrorResponse(w, http.StatusForbidden, "No active admin DID is associated with your account")
+			return "", false
+		case 1:
+			return owned[0].DID, true
+		default:
+			sendErrorResponse(w, http.StatusBadRequest, "admin_did is required: your account has more than one admin DID")
+			return "", false
+		}
+	}
+
+	err := checkAdminDID(did, user)
+	switch err {
+	case nil:
+		return did, true
+	case errUnknownAdminDID, errRevokedAdminDID, errAdminDIDNotOwned:
+		logger.InfoLogger.Printf("[ADMIN DIDS] Rejected admin DID %s for user %s: %v", did, user, err)
+		sendErrorResponse(w, http.StatusForbidden, err.Error())
+	default:
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to look up admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check admin_did")
+	}
+	return "", false
+}
+
+// canManageAdminDID reports whether user owns the admin DID or the active admin DID that added it
+func canManageAdminDID(admin *db.AdminDID, user string) (bool, error) {
+	if admin.Owner == user {
+		return true, nil
+	}
+	if admin.AddedByDID == "" {
+		return false, nil
+	}
+	parent, err := db.GetAdminDID(admin.AddedByDID)
+	if err != nil {
+		return false, err
+	}
+	return parent != nil && parent.Status == db.AdminDIDActive && parent.Owner == user, nil
+}
+
+// recordAdminDID tracks an admin DID the node has just accepted, owned by the user who added it
+func recordAdminDID(req AdminAddRequest, owner string) {
+	admin := &db.AdminDID{
+		DID:        req.NewAdminDID,
+		AddedByDID: req.ExistingAdminDID,
+		Owner:      owner,
+	}
+	if err := db.SaveAdminDID(admin); err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to record admin DID %s: %v", admin.DID, err)
+	}
+}
+
+// HandleListAdminDIDs lists known admin DIDs, optionally filtered by ?owner= and ?status=
+func HandleListAdminDIDs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != db.AdminDIDActive && status != db.AdminDIDRevoked {
+		sendErrorResponse(w, http.StatusBadRequest, "status must be active or revoked")
+		return
+	}
+
+	admins, err := db.ListAdminDIDs(query.Get("owner"), status)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to list admin DIDs: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list admin DIDs")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Admin DIDs fetched successfully", admins)
+}
+
+// HandleRevokeAdminDID revokes an admin DID in Rubxy so it can no longer be used through the proxy.
+// Only the DID's owner or the owner of the admin DID that added it may revoke it.
+// The node is not told; the DID remains an admin there.
+func HandleRevokeAdminDID(w http.ResponseWriter, r *http.Request) {
+	did := chi.URLParam(r, "did")
+	user := middleware.GetUserFromContext(r)
+
+	admin, err := db.GetAdminDID(did)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to fetch admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch admin DID")
+		return
+	}
+	if admin == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Admin DID not found")
+		return
+	}
+
+	allowed, err := canManageAdminDID(admin, user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to fetch parent of admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch admin DID")
+		return
+	}
+	if !allowed {
+		sendErrorResponse(w, http.StatusForbidden, "Only the owner of the admin DID or of the admin DID that added it can revoke it")
+		return
+	}
+
+	revoked, err := db.RevokeAdminDID(did, user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to revoke admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke admin DID")
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, http.StatusConflict, "Admin DID is already revoked")
+		return
+	}
+
+	logger.InfoLogger.Printf("[ADMIN DIDS] User %s revoked admin DID %s", user, did)
+	sendSuccessResponse(w, http.StatusOK, "Admin DID revoked", nil)
+}
+
+type AdminDIDOwnerRequest struct {
+	Owner string `json:"owner"`
+}
+
+// HandleSetAdminDIDOwner associates an active admin DID with another Rubxy account. The same
+// users who may revoke the DID may reassign it.
+func HandleSetAdminDIDOwner(w http.ResponseWriter, r *http.Request) {
+	did := chi.URLParam(r, "did")
+	user := middleware.GetUserFromContext(r)
+
+	var req AdminDIDOwnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Owner == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "owner is required")
+		return
+	}
+
+	admin, err := db.GetAdminDID(did)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to fetch admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch admin DID")
+		return
+	}
+	if admin == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Admin DID not found")
+		return
+	}
+
+	allowed, err := canManageAdminDID(admin, user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to fetch parent of admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch admin DID")
+		return
+	}
+	if !allowed {
+		sendErrorResponse(w, http.StatusForbidden, "Only the owner of the admin DID or of the admin DID that added it can reassign it")
+		return
+	}
+	if _, err := users.Role(req.Owner); err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "New owner account not found")
+		return
+	} else if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to look up account %s: %v", req.Owner, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to reassign admin DID")
+		return
+	}
+
+	updated, err := db.SetAdminDIDOwner(did, req.Owner)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN DIDS] Failed to reassign admin DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to reassign admin DID")
+		return
+	}
+	if !updated {
+		sendErrorResponse(w, http.StatusConflict, "Revoked admin DIDs cannot be reassigned")
+		return
+	}
+
+	logger.InfoLogger.Printf("[ADMIN DIDS] User %s reassigned admin DID %s from %s to %s", user, did, admin.Owner, req.Owner)
+	sendSuccessResponse(w, http.StatusOK, "Admin DID owner updated", nil)
+}
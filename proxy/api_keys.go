@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rubxy/auth"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once when a key is created; Key is never shown again
+type CreatedAPIKey struct {
+	Key string `json:"key"`
+	*db.APIKey
+}
+
+// rejectAPIKeyRequest stops API keys from managing credentials, so a leaked key cannot mint new ones
+func rejectAPIKeyRequest(w http.ResponseWriter, r *http.Request) bool {
+	if middleware.IsAPIKeyRequest(r) {
+		sendErrorResponse(w, http.StatusForbidden, "Credentials cannot be managed with an API key")
+		return true
+	}
+	return false
+}
+
+// checkGrantableScopes writes an error and returns false unless the caller may hand out every
+// one of scopes: each must be known, allowed by the caller's role, and held by the credential the
+// request was made with, so a narrowly scoped token cannot mint a wider key or client
+func checkGrantableScopes(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	username := middleware.GetUserFromContext(r)
+	allowed, err := auth.UserScopes(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[API KEYS] Failed to look up scopes for %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check scopes")
+		return false
+	}
+	held, limited := middleware.GetScopesFromContext(r)
+	for _, scope := range scopes {
+		if !auth.IsValidScope(scope) {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope))
+			return false
+		}
+		if !auth.HasScope(allowed, scope) || (limited && !auth.HasScope(held, scope)) {
+			sendErrorResponse(w, http.StatusForbidden, fmt.Sprintf("you cannot grant scope %q", scope))
+			return false
+		}
+	}
+	return true
+}
+
+// HandleCreateAPIKey creates an API key for the calling user with the requested scopes and optional expiry
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	if !checkGrantableScopes(w, r, req.Scopes) {
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		sendErrorResponse(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	apiKey := &db.APIKey{
+		Name:      req.Name,
+		Username:  middleware.GetUserFromContext(r),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	key, err := auth.GenerateAPIKey(apiKey)
+	if err != nil {
+		logger.ErrorLogger.Printf("[API KEYS] Failed to generate key: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+	if err := db.SaveAPIKey(apiKey); err != nil {
+		logger.ErrorLogger.Printf("[API KEYS] Failed to save key: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	logger.InfoLogger.Printf("[API KEYS] User %s created API key %s (%s) with scopes %v",
+		apiKey.Username, apiKey.Prefix, apiKey.Name, apiKey.Scopes)
+	sendSuccessResponse(w, http.StatusCreated, "API key created; store it now, it will not be shown again",
+		CreatedAPIKey{Key: key, APIKey: apiKey})
+}
+
+// HandleListAPIKeys lists the calling user's API keys without their secrets
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	keys, err := db.ListAPIKeys(middleware.GetUserFromContext(r))
+	if err != nil {
+		logger.ErrorLogger.Printf("[API KEYS] Failed to list keys: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "API keys fetched successfully", keys)
+}
+
+// HandleRevokeAPIKey revokes one of the calling user's API keys
+func HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid API key id")
+		return
+	}
+
+	user := middleware.GetUserFromContext(r)
+	revoked, err := db.RevokeAPIKey(id, user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[API KEYS] Failed to revoke key %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, http.StatusNotFound, "API key not found or already revoked")
+		return
+	}
+
+	logger.InfoLogger.Printf("[API KEYS] User %s revoked API key %d", user, id)
+	sendSuccessResponse(w, http.StatusOK, "API key revoked", nil)
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"net/http"
+
+	"rubxy/db"
+	"rubxy/logger"
+)
+
+// HandleListAuditEvents lists audit log events, newest first, filtered by action, actor, target,
+// outcome, from and to, with cursor pagination (limit, cursor)
+func HandleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Action:  query.Get("action"),
+		Actor:   query.Get("actor"),
+		Target:  query.Get("target"),
+		Outcome: query.Get("outcome"),
+	}
+	var err error
+	if filter.From, err = parseTimeParam(r, "from"); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.To, err = parseTimeParam(r, "to"); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	afterID, err := decodeCursor(query.Get("cursor"))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.AfterID = int64(afterID)
+
+	events, err := db.ListAuditEvents(filter)
+	if err != nil {
+		logger.ErrorLogger.Printf("[AUDIT] Failed to list events: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list audit events")
+		return
+	}
+
+	page := Page{Items: events}
+	if len(events) == filter.Limit {
+		page.NextCursor = encodeCursor(int(events[len(events)-1].ID))
+	}
+	sendSuccessResponse(w, http.StatusOK, "Audit events fetched successfully", page)
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"rubxy/db"
+	"rubxy/didkey"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxDIDBatchSize is the most public keys accepted in one batch
+	maxDIDBatchSize = 1000
+	// didBatchConcurrency is how many create-did-with-pubkey calls a batch makes at once
+	didBatchConcurrency = 4
+	// maxDIDBatchBodyBytes caps the request body of a batch, in either format
+	maxDIDBatchBodyBytes = 1 << 20
+)
+
+type DIDBatchRequest struct {
+	AdminDID   string   `json:"admin_did"`
+	PublicKeys []string `json:"public_keys"`
+}
+
+// HandleCreateDIDBatch validates a list of public keys and creates a DID for each valid one in the
+// background. Keys are sent as JSON, or as CSV (first column, optional public_key header) with
+// admin_did as a query parameter. Returns the job; poll GET /admin/dids/batch/{id} for results.
+func HandleCreateDIDBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDIDBatchBodyBytes)
+	req, err := parseDIDBatchRequest(r)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	adminDID, ok := resolveAdminDID(w, r, req.AdminDID)
+	if !ok {
+		return
+	}
+	req.AdminDID = adminDID
+	if len(req.PublicKeys) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "at least one public key is required")
+		return
+	}
+	if len(req.PublicKeys) > maxDIDBatchSize {
+		sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("a batch can contain at most %d public keys", maxDIDBatchSize))
+		return
+	}
+
+	job := &db.DIDBatchJob{
+		AdminDID:    req.AdminDID,
+		RequestedBy: middleware.GetUserFromContext(r),
+		Items:       make([]db.DIDBatchItem, len(req.PublicKeys)),
+	}
+	if err := validateDIDBatchItems(req.PublicKeys, job.Items); err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to check public keys: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check public keys")
+		return
+	}
+
+	if err := db.CreateDIDBatchJob(job); err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to create job: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to start DID batch")
+		return
+	}
+
+	logger.InfoLogger.Printf("[DID BATCH] User %s started job %d with %d keys for admin %s",
+		job.RequestedBy, job.ID, job.Total, job.AdminDID)
+	go runDIDBatch(context.Background(), job.ID)
+
+	sendSuccessResponse(w, http.StatusAccepted, "DID batch started", job)
+}
+
+// HandleGetDIDBatch returns a batch job with the per-key results to the user who started it
+func HandleGetDIDBatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid batch id")
+		return
+	}
+
+	job, err := db.GetDIDBatchJob(id)
+	if err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to fetch job %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch DID batch")
+		return
+	}
+	if job == nil || job.RequestedBy != middleware.GetUserFromContext(r) {
+		sendErrorResponse(w, http.StatusNotFound, "DID batch not found")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "DID batch fetched successfully", job)
+}
+
+func parseDIDBatchRequest(r *http.Request) (*DIDBatchRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" {
+		var req DIDBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, fmt.Errorf("Invalid request body")
+		}
+		return &req, nil
+	}
+
+	req := &DIDBatchRequest{AdminDID: r.URL.Query().Get("admin_did")}
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV: %v", err)
+		}
+		key := strings.TrimSpace(record[0])
+		if line == 1 && strings.EqualFold(key, "public_key") {
+			continue
+		}
+		if key != "" {
+			req.PublicKeys = append(req.PublicKeys, key)
+		}
+		if len(req.PublicKeys) > maxDIDBatchSize {
+			break
+		}
+	}
+	return req, nil
+}
+
+// validateDIDBatchItems fills in one item per key. Keys that do not parse, repeat an earlier key
+// in the batch or already have a DID are rejected up front; the rest are left pending.
+func validateDIDBatchItems(keys []string, items []db.DIDBatchItem) error {
+	seen := map[string]int{}
+	for i, encoded := range keys {
+		item := &items[i]
+		item.Position = i
+		item.PublicKey = encoded
+		item.Status = db.DIDBatchItemRejected
+
+		publicKey, err := didkey.Parse(encoded)
+		if err != nil {
+			item.Error = err.Error()
+			continue
+		}
+		item.NormalizedKey = publicKey.Hex()
+		item.KeyType = string(publicKey.Type)
+
+		if first, ok := seen[item.NormalizedKey]; ok {
+			item.Error = fmt.Sprintf("duplicate of the public key at position %d", first)
+			continue
+		}
+		seen[item.NormalizedKey] = i
+
+		used, err := db.PublicKeyUsed(item.NormalizedKey)
+		if err != nil {
+			return err
+		}
+		if used {
+			item.Error = "a DID has already been created for this public key"
+			continue
+		}
+		item.Status = db.DIDBatchItemPending
+	}
+	return nil
+}
+
+// ResumeDIDBatches finishes the jobs left running when an instance stopped. Each job runs under
+// its own advisory lock, so jobs still being worked on by a live instance are skipped. Items whose
+// DID was being created when the instance stopped fail, as their public key is already reserved.
+func ResumeDIDBatches(ctx context.Context) {
+	ids, err := db.RunningDIDBatchJobs()
+	if err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to list unfinished jobs: %v", err)
+		return
+	}
+	for _, id := range ids {
+		runDIDBatch(ctx, id)
+	}
+}
+
+// runDIDBatch creates the DIDs of a job's pending items, at most didBatchConcurrency at a time,
+// unless another instance is already running the job. The job is loaded once the lock is held,
+// so a job that finished in the meantime is left alone.
+func runDIDBatch(ctx context.Context, id int) {
+	finished := false
+	_, err := db.WithObjectLock(ctx, db.DIDBatchLockClass, int32(id), func() error {
+		job, err := db.GetDIDBatchJob(id)
+		if err != nil || job == nil || job.Status != db.DIDBatchRunning {
+			return err
+		}
+		createBatchDIDs(job)
+		finished = true
+		return db.FinishDIDBatchJob(job.ID)
+	})
+	if err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to run job %d: %v", id, err)
+		return
+	}
+	if finished {
+		logger.InfoLogger.Printf("[DID BATCH] Job %d finished", id)
+	}
+}
+
+func createBatchDIDs(job *db.DIDBatchJob) {
+	sem := make(chan struct{}, didBatchConcurrency)
+	var wg sync.WaitGroup
+	for i := range job.Items {
+		if job.Items[i].Status != db.DIDBatchItemPending {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *db.DIDBatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			createBatchDID(job, item)
+		}(&job.Items[i])
+	}
+	wg.Wait()
+}
+
+func createBatchDID(job *db.DIDBatchJob, item *db.DIDBatchItem) {
+	req := CreateDIDRequest{AdminDID: job.AdminDID, PublicKey: item.NormalizedKey}
+	record := &db.DIDRecord{
+		PublicKey: item.NormalizedKey,
+		KeyType:   item.KeyType,
+		AdminDID:  job.AdminDID,
+		CreatedBy: job.RequestedBy,
+	}
+	// The key may have been used since the job was validated
+	err := db.ReserveDID(record)
+	if err == nil {
+		var apiResp *CreateDIDResponse
+		if apiResp, err = CreateDID(req); err != nil {
+			if err := db.ReleaseDID(record.ID); err != nil {
+				logger.ErrorLogger.Printf("[DID BATCH] Failed to release public key %s: %v", item.NormalizedKey, err)
+			}
+		} else {
+			item.DID = getStringValue(apiResp.Data["did"], "")
+		}
+	}
+
+	if err != nil {
+		item.Status = db.DIDBatchItemFailed
+		item.Error = err.Error()
+		auditDIDCreate(nil, job.RequestedBy, req, "", err)
+	} else {
+		item.Status = db.DIDBatchItemCreated
+		if err := db.CompleteDID(record.ID, item.DID); err != nil {
+			logger.ErrorLogger.Printf("[DID BATCH] Failed to record DID %s: %v", item.DID, err)
+		}
+		auditDIDCreate(nil, job.RequestedBy, req, item.DID, nil)
+	}
+
+	if err := db.FinishDIDBatchItem(item); err != nil {
+		logger.ErrorLogger.Printf("[DID BATCH] Failed to store result of job %d position %d: %v", job.ID, item.Position, err)
+	}
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"rubxy/audit"
+	"rubxy/auth"
+	"rubxy/db"
+	"rubxy/didkey"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleGetDID looks up a DID in Rubxy's local registry
+func HandleGetDID(w http.ResponseWriter, r *http.Request) {
+	did := chi.URLParam(r, "did")
+	if did == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "did is required")
+		return
+	}
+
+	record, err := db.GetDID(did)
+	if err != nil {
+		logger.ErrorLogger.Printf("[DID REGISTRY] Failed to fetch DID %s: %v", did, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch DID")
+		return
+	}
+	if record == nil {
+		sendErrorResponse(w, http.StatusNotFound, "DID not found")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "DID fetched successfully", record)
+}
+
+// HandleListDIDs searches the registry with q, admin_did and created_by, paginated with limit and cursor
+func HandleListDIDs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sendDIDPage(w, r, db.DIDFilter{
+		Search:    query.Get("q"),
+		AdminDID:  query.Get("admin_did"),
+		CreatedBy: query.Get("created_by"),
+	})
+}
+
+// HandleListMyDIDs lists the DIDs created at the calling user's request or claimed by them
+func HandleListMyDIDs(w http.ResponseWriter, r *http.Request) {
+	sendDIDPage(w, r, db.DIDFilter{Account: middleware.GetUserFromContext(r)})
+}
+
+// ClaimDIDRequest answers a challenge from /auth/did/challenge to prove the caller holds a DID's key
+type ClaimDIDRequest struct {
+	DID       string `json:"did"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// HandleClaimDID makes the caller the owner of a DID whose key they prove they hold, after which
+// the DID can log in to their account at /auth/did/verify
+func HandleClaimDID(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+	var req ClaimDIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DID == "" || req.Nonce == "" || req.Signature == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "did, nonce and signature are required")
+		return
+	}
+	signature, err := didkey.DecodeSignature(req.Signature)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := middleware.GetUserFromContext(r)
+	_, err = auth.VerifyDIDChallenge(req.DID, req.Nonce, signature)
+	if err == nil {
+		err = db.ClaimDID(req.DID, user)
+	}
+	switch err {
+	case nil:
+	case auth.ErrDIDChallenge, auth.ErrUnknownDID, auth.ErrDIDSignature:
+		logger.InfoLogger.Printf("[DID REGISTRY] User %s failed to claim DID %s: %v", user, req.DID, err)
+		sendErrorResponse(w, http.StatusForbidden, err.Error())
+		return
+	case db.ErrDIDClaimed:
+		sendErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	default:
+		logger.ErrorLogger.Printf("[DID REGISTRY] Failed to claim DID %s for %s: %v", req.DID, user, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to claim DID")
+		return
+	}
+
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionDIDClaim,
+		Actor:   user,
+		Target:  req.DID,
+		Outcome: audit.OutcomeSuccess,
+	})
+	logger.InfoLogger.Printf("[DID REGISTRY] User %s claimed DID %s", user, req.DID)
+	sendSuccessResponse(w, http.StatusOK, "DID claimed", map[string]string{"did": req.DID, "owner": user})
+}
+
+func sendDIDPage(w http.ResponseWriter, r *http.Request, filter db.DIDFilter) {
+	var err error
+	if filter.Limit, err = parseLimit(r); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.AfterID, err = decodeCursor(r.URL.Query().Get("cursor")); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dids, err := db.ListDIDs(filter)
+	if err != nil {
+		logger.ErrorLogger.Printf("[DID REGISTRY] Failed to list DIDs: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list DIDs")
+		return
+	}
+
+	page := Page{Items: dids}
+	if len(dids) == filter.Limit {
+		page.NextCursor = encodeCursor(dids[len(dids)-1].ID)
+	}
+	sendSuccessResponse(w, http.StatusOK, "DIDs fetched successfully", page)
+}
@@ -9,7 +9,11 @@ import (
 	"net/url"
 	"os"
 
+	"rubxy/audit"
+	"rubxy/db"
+	"rubxy/didkey"
 	"rubxy/logger"
+	"rubxy/middleware"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -58,6 +62,23 @@ type SCTData struct {
 	SCTDataReply json.RawMessage `json:"SCTDataReply"`
 }
 
+// RewardTransferResult is the normalized outcome of an accepted reward transfer
+type RewardTransferResult struct {
+	StatusCode int
+	Message    string
+	Result     map[string]interface{}
+}
+
+// UpstreamError describes a failed call to the external API and the status code to report for it
+type UpstreamError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Message
+}
+
 type FinalResponse struct {
 	Status  bool        `json:"status"`
 	Message string      `json:"message"`
@@ -105,12 +126,51 @@ func sendErrorResponse(w http.ResponseWriter, statusCode int, message string) {
 	}
 }
 
+// sendSuccessResponse sends a successful FinalResponse with the given status code
+func sendSuccessResponse(w http.ResponseWriter, statusCode int, message string, result interface{}) {
+	finalResp := FinalResponse{
+		Status:  true,
+		Message: message,
+		Result:  result,
+	}
+
+	// Encode to buffer first to handle errors before writing to response
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(finalResp); err != nil {
+		logger.ErrorLogger.Printf("Failed to encode final response: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to encode final response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logger.ErrorLogger.Printf("Failed to write response: %v", err)
+	}
+}
+
+// auditActivityAdd records an activity addition and whether the node accepted it
+func auditActivityAdd(r *http.Request, req ActivityAddRequest, ok bool) {
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionActivityAdd,
+		Actor:   middleware.GetUserFromContext(r),
+		Target:  req.ActivityID,
+		Outcome: audit.Outcome(ok),
+		Payload: req,
+	})
+}
+
 func HandleAdminActivityAdd(w http.ResponseWriter, r *http.Request) {
 	var activityReq ActivityAddRequest
 	if err := json.NewDecoder(r.Body).Decode(&activityReq); err != nil {
 		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	adminDID, ok := resolveAdminDID(w, r, activityReq.AdminDID)
+	if !ok {
+		return
+	}
+	activityReq.AdminDID = adminDID
 
 	reqBody, err := json.Marshal(activityReq)
 	if err != nil {
@@ -140,6 +200,7 @@ func HandleAdminActivityAdd(w http.ResponseWriter, r *http.Request) {
 	var sctData SCTData
 	if err := json.Unmarshal([]byte(transferResp.Data), &sctData); err != nil {
 		// if 'data' is null or invalid, return a fallback error
+		auditActivityAdd(r, activityReq, false)
 		finalResp := FinalResponse{
 			Status:  false,
 			Message: transferResp.Message,
@@ -150,6 +211,19 @@ func HandleAdminActivityAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditActivityAdd(r, activityReq, sctData.Status)
+	if sctData.Status {
+		activity := &db.Activity{
+			ActivityID:   activityReq.ActivityID,
+			RewardPoints: activityReq.RewardPoints,
+			AdminDID:     activityReq.AdminDID,
+			AddedBy:      middleware.GetUserFromContext(r),
+		}
+		if err := db.SaveActivity(activity); err != nil {
+			logger.ErrorLogger.Printf("[ADMIN ACTIVITY ADD] Failed to record activity %s: %v", activity.ActivityID, err)
+		}
+	}
+
 	finalResp := FinalResponse{
 		Status:  sctData.Status,
 		Message: "Activity added successfully",
@@ -249,13 +323,54 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 	logger.InfoLogger.Printf("[ADMIN PAYOUTS] Parsed payload - ActivityID: %v, UserDID: %s, AdminDID: %s",
 		reqPayload.ActivityID, reqPayload.UserDID, reqPayload.AdminDID)
 
-	// Marshal the payload to JSON
-	jsonData, err := json.Marshal(reqPayload)
+	adminDID, ok := resolveAdminDID(w, r, reqPayload.AdminDID)
+	if !ok {
+		return
+	}
+	reqPayload.AdminDID = adminDID
+
+	transfer, err := TransferRewards(reqPayload)
+	recordPayout(r, reqPayload, middleware.GetUserFromContext(r), nil, transfer, err)
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to marshal request")
+		if upstreamErr, ok := err.(*UpstreamError); ok {
+			sendErrorResponse(w, upstreamErr.StatusCode, upstreamErr.Message)
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
+	finalResp := FinalResponse{
+		Status:  true,
+		Message: transfer.Message,
+		Result:  transfer.Result,
+	}
+
+	logger.InfoLogger.Printf("[ADMIN PAYOUTS] Sending final response: %+v", finalResp)
+
+	// Encode to buffer first to handle errors before writing to response
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(finalResp); err != nil {
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to encode final response: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to encode final response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to write response: %v", err)
+	}
+}
+
+// TransferRewards forwards a reward transfer to the external API and normalizes its response.
+// Failures are returned as *UpstreamError carrying the status code to report to the caller.
+func TransferRewards(payload RewardTransferRequest) (*RewardTransferResult, error) {
+	// Marshal the payload to JSON
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to marshal request"}
+	}
+
 	// Send POST request to the external API with timeout (5 minutes)
 	logger.InfoLogger.Printf("[ADMIN PAYOUTS] Forwarding request to: http://localhost:9000/api/rewards/transfer")
 
@@ -263,11 +378,9 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to call external API: %v", err)
 		if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
-			sendErrorResponse(w, http.StatusGatewayTimeout, "External API request timed out")
-		} else {
-			sendErrorResponse(w, http.StatusBadGateway, fmt.Sprintf("Failed to call external API: %v", err))
+			return nil, &UpstreamError{StatusCode: http.StatusGatewayTimeout, Message: "External API request timed out"}
 		}
-		return
+		return nil, &UpstreamError{StatusCode: http.StatusBadGateway, Message: fmt.Sprintf("Failed to call external API: %v", err)}
 	}
 	defer resp.Body.Close()
 
@@ -278,8 +391,7 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] External API returned error status %d: %s", resp.StatusCode, string(bodyBytes))
-		sendErrorResponse(w, resp.StatusCode, fmt.Sprintf("External API returned error: %s", string(bodyBytes)))
-		return
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("External API returned error: %s", string(bodyBytes))}
 	}
 
 	logger.InfoLogger.Printf("[ADMIN PAYOUTS] External API returned success status: %d", resp.StatusCode)
@@ -288,8 +400,7 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 	var apiResp map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
 		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to parse external API response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to parse external API response")
-		return
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to parse external API response"}
 	}
 
 	logger.InfoLogger.Printf("[ADMIN PAYOUTS] External API response: %+v", apiResp)
@@ -304,8 +415,7 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 				message = msg
 			}
 			logger.ErrorLogger.Printf("[ADMIN PAYOUTS] External API returned failure status: %s", status)
-			sendErrorResponse(w, http.StatusBadGateway, message)
-			return
+			return nil, &UpstreamError{StatusCode: http.StatusBadGateway, Message: message}
 		}
 	} else {
 		// For 202 responses, log the status but still process it as success
@@ -328,26 +438,11 @@ func HandleAdminRewardTransfer(w http.ResponseWriter, r *http.Request) {
 		result["block_id"] = blockID
 	}
 
-	finalResp := FinalResponse{
-		Status:  true,
-		Message: getStringValue(apiResp["message"], "Reward transfer completed successfully"),
-		Result:  result,
-	}
-
-	logger.InfoLogger.Printf("[ADMIN PAYOUTS] Sending final response: %+v", finalResp)
-
-	// Encode to buffer first to handle errors before writing to response
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(finalResp); err != nil {
-		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to encode final response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to encode final response")
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to write response: %v", err)
-	}
+	return &RewardTransferResult{
+		StatusCode: resp.StatusCode,
+		Message:    getStringValue(apiResp["message"], "Reward transfer completed successfully"),
+		Result:     result,
+	}, nil
 }
 
 // HandleAdminPayoutStatus fetches reward transfer status by request_id and wraps it into FinalResponse
@@ -359,38 +454,13 @@ func HandleAdminPayoutStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build upstream status URL
-	statusURL := fmt.Sprintf("http://localhost:9000/api/rewards/status/%s", url.PathEscape(requestID))
-
-	// Create GET request
-	req, err := http.NewRequest("GET", statusURL, nil)
+	apiResp, err := FetchPayoutStatus(requestID)
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to create request")
-		return
-	}
-
-	// Call upstream status API
-	resp, err := SharedHTTPClient.Do(req)
-	if err != nil {
-		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Failed to call status API: %v", err)
-		sendErrorResponse(w, http.StatusBadGateway, fmt.Sprintf("Failed to call status API: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	// Check HTTP status code
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Status API error - Status: %d, Response: %s", resp.StatusCode, string(bodyBytes))
-		sendErrorResponse(w, resp.StatusCode, fmt.Sprintf("Status API returned error: %s", string(bodyBytes)))
-		return
-	}
-
-	// Parse upstream response
-	var apiResp map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Failed to parse status API response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to parse status API response")
+		if upstreamErr, ok := err.(*UpstreamError); ok {
+			sendErrorResponse(w, upstreamErr.StatusCode, upstreamErr.Message)
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -431,6 +501,43 @@ func HandleAdminPayoutStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// FetchPayoutStatus calls the external reward status API for request_id and returns its decoded response.
+// Failures are returned as *UpstreamError carrying the status code to report to the caller.
+func FetchPayoutStatus(requestID string) (map[string]interface{}, error) {
+	// Build upstream status URL
+	statusURL := fmt.Sprintf("http://localhost:9000/api/rewards/status/%s", url.PathEscape(requestID))
+
+	// Create GET request
+	req, err := http.NewRequest("GET", statusURL, nil)
+	if err != nil {
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to create request"}
+	}
+
+	// Call upstream status API
+	resp, err := SharedHTTPClient.Do(req)
+	if err != nil {
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Failed to call status API: %v", err)
+		return nil, &UpstreamError{StatusCode: http.StatusBadGateway, Message: fmt.Sprintf("Failed to call status API: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	// Check HTTP status code
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Status API error - Status: %d, Response: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("Status API returned error: %s", string(bodyBytes))}
+	}
+
+	// Parse upstream response
+	var apiResp map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS STATUS] Failed to parse status API response: %v", err)
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to parse status API response"}
+	}
+
+	return apiResp, nil
+}
+
 func HandleGetAllActivities(w http.ResponseWriter, r *http.Request) {
 	const filePath = "/home/rubix/github/ymca-wellness-cafe/dappServer/test.json"
 
@@ -467,6 +574,28 @@ func HandleAdminAddUser(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if req.NewAdminDID == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "new_admin_did is required")
+		return
+	}
+
+	// The new admin DID will belong to the caller, so they must control the DID vouching for it
+	existingAdminDID, ok := resolveAdminDID(w, r, req.ExistingAdminDID)
+	if !ok {
+		return
+	}
+	req.ExistingAdminDID = existingAdminDID
+
+	switch err := db.CheckNewAdminDID(req.NewAdminDID, middleware.GetUserFromContext(r)); err {
+	case nil:
+	case db.ErrAdminDIDTaken, db.ErrAdminDIDRevoked:
+		sendErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	default:
+		logger.ErrorLogger.Printf("[ADMIN ADD USER] Failed to look up admin DID %s: %v", req.NewAdminDID, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check new_admin_did")
+		return
+	}
 
 	reqBody, err := json.Marshal(req)
 	if err != nil {
@@ -501,6 +630,17 @@ func HandleAdminAddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionAdminDIDAdd,
+		Actor:   middleware.GetUserFromContext(r),
+		Target:  req.NewAdminDID,
+		Outcome: audit.Outcome(sctData.Status),
+		Payload: req,
+	})
+	if sctData.Status {
+		recordAdminDID(req, middleware.GetUserFromContext(r))
+	}
+
 	// Final clean response
 	finalResp := FinalResponse{
 		Status:  sctData.Status,
@@ -601,24 +741,110 @@ func HandleCreateDID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if reqPayload.AdminDID == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "admin_did is required")
-		return
-	}
 	if reqPayload.PublicKey == "" {
 		sendErrorResponse(w, http.StatusBadRequest, "public_key is required")
 		return
 	}
+	adminDID, ok := resolveAdminDID(w, r, reqPayload.AdminDID)
+	if !ok {
+		return
+	}
+	reqPayload.AdminDID = adminDID
+
+	// Decode and validate the key so malformed keys never reach the node
+	publicKey, err := didkey.Parse(reqPayload.PublicKey)
+	if err != nil {
+		logger.ErrorLogger.Printf("[CREATE DID] Invalid public key: %v", err)
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reqPayload.PublicKey = publicKey.Hex()
+
+	didRecord := &db.DIDRecord{
+		PublicKey: reqPayload.PublicKey,
+		KeyType:   string(publicKey.Type),
+		AdminDID:  reqPayload.AdminDID,
+		CreatedBy: middleware.GetUserFromContext(r),
+	}
+	if err := db.ReserveDID(didRecord); err == db.ErrPublicKeyUsed {
+		sendErrorResponse(w, http.StatusConflict, "A DID has already been created for this public_key")
+		return
+	} else if err != nil {
+		logger.ErrorLogger.Printf("[CREATE DID] Failed to reserve public key: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to check public key")
+		return
+	}
 
 	logger.InfoLogger.Printf("[CREATE DID] Parsed payload - AdminDID: %s, PublicKey: %s", reqPayload.AdminDID, reqPayload.PublicKey)
 
-	// Marshal the payload to JSON
-	jsonData, err := json.Marshal(reqPayload)
+	apiResp, err := CreateDID(reqPayload)
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to marshal request")
+		if err := db.ReleaseDID(didRecord.ID); err != nil {
+			logger.ErrorLogger.Printf("[CREATE DID] Failed to release public key %s: %v", reqPayload.PublicKey, err)
+		}
+		auditDIDCreate(r, didRecord.CreatedBy, reqPayload, "", err)
+		if upstreamErr, ok := err.(*UpstreamError); ok {
+			sendErrorResponse(w, upstreamErr.StatusCode, upstreamErr.Message)
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	didRecord.DID = getStringValue(apiResp.Data["did"], "")
+	if err := db.CompleteDID(didRecord.ID, didRecord.DID); err != nil {
+		logger.ErrorLogger.Printf("[CREATE DID] Failed to record DID %s: %v", didRecord.DID, err)
+	}
+	auditDIDCreate(r, didRecord.CreatedBy, reqPayload, didRecord.DID, nil)
+
+	// Prepare the final response
+	finalResp := FinalResponse{
+		Status:  apiResp.Status,
+		Message: "DID created successfully",
+		Result:  apiResp.Data,
+	}
+
+	logger.InfoLogger.Printf("[CREATE DID] Sending final response: %+v", finalResp)
+
+	// Encode to buffer first to handle errors before writing to response
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(finalResp); err != nil {
+		logger.ErrorLogger.Printf("[CREATE DID] Failed to encode final response: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to encode final response")
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logger.ErrorLogger.Printf("[CREATE DID] Failed to write response: %v", err)
+	}
+}
+
+// auditDIDCreate records a DID creation requested by actor; the target is the new DID, or the
+// public key if creation failed. r is nil for batch jobs.
+func auditDIDCreate(r *http.Request, actor string, req CreateDIDRequest, did string, err error) {
+	target := did
+	if err != nil {
+		target = req.PublicKey
+	}
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionDIDCreate,
+		Actor:   actor,
+		Target:  target,
+		Outcome: audit.Outcome(err == nil),
+		Payload: req,
+	})
+}
+
+// CreateDID forwards a validated DID creation request to the external API and returns its response.
+// Failures are returned as *UpstreamError carrying the status code to report to the caller.
+func CreateDID(payload CreateDIDRequest) (*CreateDIDResponse, error) {
+	// Marshal the payload to JSON
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to marshal request"}
+	}
+
 	// Send POST request to the external API
 	logger.InfoLogger.Printf("[CREATE DID] Forwarding request to: http://localhost:9000/api/create-did-with-pubkey")
 
@@ -626,11 +852,9 @@ func HandleCreateDID(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.ErrorLogger.Printf("[CREATE DID] Failed to call external API: %v", err)
 		if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
-			sendErrorResponse(w, http.StatusGatewayTimeout, "External API request timed out")
-		} else {
-			sendErrorResponse(w, http.StatusBadGateway, fmt.Sprintf("Failed to call external API: %v", err))
+			return nil, &UpstreamError{StatusCode: http.StatusGatewayTimeout, Message: "External API request timed out"}
 		}
-		return
+		return nil, &UpstreamError{StatusCode: http.StatusBadGateway, Message: fmt.Sprintf("Failed to call external API: %v", err)}
 	}
 	defer resp.Body.Close()
 
@@ -640,8 +864,7 @@ func HandleCreateDID(w http.ResponseWriter, r *http.Request) {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.ErrorLogger.Printf("[CREATE DID] Failed to read response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to read response")
-		return
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to read response"}
 	}
 
 	logger.InfoLogger.Printf("[CREATE DID] External API response body: %s", string(respBody))
@@ -649,16 +872,14 @@ func HandleCreateDID(w http.ResponseWriter, r *http.Request) {
 	// Check if the external API returned an error status
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		logger.ErrorLogger.Printf("[CREATE DID] External API returned error status %d: %s", resp.StatusCode, string(respBody))
-		sendErrorResponse(w, resp.StatusCode, fmt.Sprintf("External API returned error: %s", string(respBody)))
-		return
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("External API returned error: %s", string(respBody))}
 	}
 
 	// Parse the response
 	var apiResp CreateDIDResponse
 	if err := json.Unmarshal(respBody, &apiResp); err != nil {
 		logger.ErrorLogger.Printf("[CREATE DID] Failed to parse external API response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to parse external API response")
-		return
+		return nil, &UpstreamError{StatusCode: http.StatusInternalServerError, Message: "Failed to parse external API response"}
 	}
 
 	logger.InfoLogger.Printf("[CREATE DID] External API response parsed: %+v", apiResp)
@@ -666,29 +887,8 @@ func HandleCreateDID(w http.ResponseWriter, r *http.Request) {
 	// Check the status field
 	if !apiResp.Status {
 		logger.ErrorLogger.Printf("[CREATE DID] External API returned status false")
-		sendErrorResponse(w, http.StatusBadGateway, "DID creation failed")
-		return
-	}
-
-	// Prepare the final response
-	finalResp := FinalResponse{
-		Status:  apiResp.Status,
-		Message: "DID created successfully",
-		Result:  apiResp.Data,
+		return nil, &UpstreamError{StatusCode: http.StatusBadGateway, Message: "DID creation failed"}
 	}
 
-	logger.InfoLogger.Printf("[CREATE DID] Sending final response: %+v", finalResp)
-
-	// Encode to buffer first to handle errors before writing to response
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(finalResp); err != nil {
-		logger.ErrorLogger.Printf("[CREATE DID] Failed to encode final response: %v", err)
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to encode final response")
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		logger.ErrorLogger.Printf("[CREATE DID] Failed to write response: %v", err)
-	}
+	return &apiResp, nil
 }
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+)
+
+// flushEvery is how many rows are written between flushes to the client
+const flushEvery = 500
+
+// exportWriter writes rows as CSV or NDJSON, optionally gzip-compressed, flushing as it goes
+type exportWriter struct {
+	out     io.Writer
+	gz      *gzip.Writer
+	csv     *csv.Writer
+	json    *json.Encoder
+	flusher http.Flusher
+	rows    int
+}
+
+func newExportWriter(w http.ResponseWriter, r *http.Request, name string, header []string) (*exportWriter, error) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return nil, fmt.Errorf("format must be csv or ndjson")
+	}
+
+	useGzip := query.Get("gzip") == "true" || acceptsGzip(r.Header.Get("Accept-Encoding"))
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if useGzip {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	ew := &exportWriter{out: w}
+	ew.flusher, _ = w.(http.Flusher)
+	if useGzip {
+		ew.gz = gzip.NewWriter(w)
+		ew.out = ew.gz
+	}
+	if format == "csv" {
+		ew.csv = csv.NewWriter(ew.out)
+		if err := ew.csv.Write(header); err != nil {
+			return nil, err
+		}
+	} else {
+		ew.json = json.NewEncoder(ew.out)
+	}
+	return ew, nil
+}
+
+// write emits one row: record for CSV, v for NDJSON
+func (ew *exportWriter) write(record []string, v interface{}) error {
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(escapeCSVRecord(record))
+	} else {
+		err = ew.json.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.rows++
+	if ew.rows%flushEvery == 0 {
+		return ew.flush()
+	}
+	return nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, honouring q-values so that
+// "gzip;q=0" refuses it; a wildcard applies when gzip is not listed
+func acceptsGzip(header string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			wildcardQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
+
+// escapeCSVCell prefixes cells that a spreadsheet would read as a formula with a quote
+func escapeCSVCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// escapeCSVRecord applies escapeCSVCell to every cell of record
+func escapeCSVRecord(record []string) []string {
+	escaped := make([]string, len(record))
+	for i, cell := range record {
+		escaped[i] = escapeCSVCell(cell)
+	}
+	return escaped
+}
+
+func (ew *exportWriter) flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if ew.gz != nil {
+		if err := ew.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if ew.flusher != nil {
+		ew.flusher.Flush()
+	}
+	return nil
+}
+
+func (ew *exportWriter) close() error {
+	if err := ew.flush(); err != nil {
+		return err
+	}
+	if ew.gz != nil {
+		return ew.gz.Close()
+	}
+	return nil
+}
+
+// parseExportRange reads the from/to query parameters, writing an error response if they are invalid
+func parseExportRange(w http.ResponseWriter, r *http.Request) (*time.Time, *time.Time, bool) {
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
+// runExport sets up the writer and streams rows with stream. Once streaming has started the status
+// code is already sent, so failures are logged and the response is cut short.
+func runExport(w http.ResponseWriter, r *http.Request, name string, header []string,
+	stream func(ew *exportWriter, from, to *time.Time) error) {
+	from, to, ok := parseExportRange(w, r)
+	if !ok {
+		return
+	}
+
+	ew, err := newExportWriter(w, r, name, header)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logger.InfoLogger.Printf("[EXPORT] User %s exporting %s (%s)", middleware.GetUserFromContext(r), name, r.URL.RawQuery)
+	if err := stream(ew, from, to); err != nil {
+		logger.ErrorLogger.Printf("[EXPORT] %s export failed after %d rows: %v", name, ew.rows, err)
+		return
+	}
+	if err := ew.close(); err != nil {
+		logger.ErrorLogger.Printf("[EXPORT] Failed to finish %s export: %v", name, err)
+		return
+	}
+	logger.InfoLogger.Printf("[EXPORT] %s export finished with %d rows", name, ew.rows)
+}
+
+// HandleExportPayouts streams recorded payouts as CSV or NDJSON
+func HandleExportPayouts(w http.ResponseWriter, r *http.Request) {
+	header := []string{"id", "admin_did", "user_did", "activity_id", "status", "http_status", "request_id",
+		"transaction_id", "block_id", "message", "requested_by", "schedule_id", "created_at"}
+
+	runExport(w, r, "payouts", header, func(ew *exportWriter, from, to *time.Time) error {
+		return db.StreamPayouts(r.Context(), from, to, func(p *db.Payout) error {
+			scheduleID := ""
+			if p.ScheduleID != nil {
+				scheduleID = strconv.Itoa(*p.ScheduleID)
+			}
+			return ew.write([]string{strconv.Itoa(p.ID), p.AdminDID, p.UserDID, strings.Join(p.ActivityIDs, ";"),
+				p.Status, strconv.Itoa(p.HTTPStatus), p.RequestID, p.TransactionID, p.BlockID, p.Message,
+				p.RequestedBy, scheduleID, p.CreatedAt.Format(time.RFC3339)}, p)
+		})
+	})
+}
+
+// HandleExportActivities streams recorded activities as CSV or NDJSON
+func HandleExportActivities(w http.ResponseWriter, r *http.Request) {
+	header := []string{"activity_id", "reward_points", "admin_did", "added_by", "created_at"}
+
+	runExport(w, r, "activities", header, func(ew *exportWriter, from, to *time.Time) error {
+		return db.StreamActivities(r.Context(), from, to, func(a *db.Activity) error {
+			return ew.write([]string{a.ActivityID, strconv.Itoa(a.RewardPoints), a.AdminDID, a.AddedBy,
+				a.CreatedAt.Format(time.RFC3339)}, a)
+		})
+	})
+}
+
+// HandleExportUsers streams Rubxy accounts (never their credentials) as CSV or NDJSON
+func HandleExportUsers(w http.ResponseWriter, r *http.Request) {
+	header := []string{"id", "username", "created_at"}
+
+	runExport(w, r, "users", header, func(ew *exportWriter, from, to *time.Time) error {
+		return db.StreamAccounts(r.Context(), from, to, func(a *db.Account) error {
+			return ew.write([]string{strconv.Itoa(a.ID), a.Username, a.CreatedAt.Format(time.RFC3339)}, a)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rubxy/auth"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+	"rubxy/users"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type InviteRequest struct {
+	Note      string     `json:"note"`
+	MaxUses   int        `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedInvite is returned once when an invite code is created; Code is never shown again
+type CreatedInvite struct {
+	Code string `json:"code"`
+	*db.InviteCode
+}
+
+// requireAdminRole writes a 403 and returns false unless the caller has the admin role
+func requireAdminRole(w http.ResponseWriter, r *http.Request) bool {
+	user := middleware.GetUserFromContext(r)
+	role, err := users.Role(user)
+	if err != nil || role != users.RoleAdmin {
+		logger.InfoLogger.Printf("[AUTH] User %s is not an admin - Path: %s", user, r.URL.Path)
+		sendErrorResponse(w, http.StatusForbidden, "This action requires the admin role")
+		return false
+	}
+	return true
+}
+
+// HandleCreateInvite issues an invite code usable max_uses times (default 1) until the optional expiry
+func HandleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "max_uses must be positive")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		sendErrorResponse(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	invite := &db.InviteCode{
+		Note:      req.Note,
+		CreatedBy: middleware.GetUserFromContext(r),
+		MaxUses:   req.MaxUses,
+		ExpiresAt: req.ExpiresAt,
+	}
+	code, err := auth.GenerateInviteCode(invite)
+	if err != nil {
+		logger.ErrorLogger.Printf("[INVITES] Failed to generate code: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to generate invite code")
+		return
+	}
+	if err := db.SaveInviteCode(invite); err != nil {
+		logger.ErrorLogger.Printf("[INVITES] Failed to save code: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to create invite code")
+		return
+	}
+
+	logger.InfoLogger.Printf("[INVITES] User %s created invite %s for %d uses", invite.CreatedBy, invite.Prefix, invite.MaxUses)
+	sendSuccessResponse(w, http.StatusCreated, "Invite code created; store it now, it will not be shown again",
+		CreatedInvite{Code: code, InviteCode: invite})
+}
+
+// HandleListInvites lists every invite code without its secret
+func HandleListInvites(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	invites, err := db.ListInviteCodes()
+	if err != nil {
+		logger.ErrorLogger.Printf("[INVITES] Failed to list codes: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list invite codes")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Invite codes fetched successfully", invites)
+}
+
+// HandleRevokeInvite revokes an invite code so it cannot be used any more
+func HandleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid invite id")
+		return
+	}
+
+	revoked, err := db.RevokeInviteCode(id)
+	if err != nil {
+		logger.ErrorLogger.Printf("[INVITES] Failed to revoke code %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke invite code")
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, http.StatusNotFound, "Invite code not found or already revoked")
+		return
+	}
+
+	logger.InfoLogger.Printf("[INVITES] User %s revoked invite %d", middleware.GetUserFromContext(r), id)
+	sendSuccessResponse(w, http.StatusOK, "Invite code revoked", nil)
+}
@@ -0,0 +1,15 @@
+package proxy
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// HandleMetrics serves the process metrics published with expvar, including the janitor's
+// counts of deleted rows
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"rubxy/auth"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type OAuthClientRequest struct {
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// CreatedOAuthClient is returned once when a client is registered; ClientSecret is never shown again
+type CreatedOAuthClient struct {
+	ClientSecret string `json:"client_secret"`
+	*db.OAuthClient
+}
+
+// HandleCreateOAuthClient registers an OAuth2 client owned by the calling user
+func HandleCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	var req OAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	if !checkGrantableScopes(w, r, req.Scopes) {
+		return
+	}
+	if len(req.GrantTypes) == 0 {
+		req.GrantTypes = []string{auth.GrantClientCredentials}
+	}
+	for _, grantType := range req.GrantTypes {
+		if !auth.IsValidGrantType(grantType) {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("unsupported grant type %q", grantType))
+			return
+		}
+	}
+	if req.RedirectURIs == nil {
+		req.RedirectURIs = []string{}
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() || u.Fragment != "" ||
+			(u.Scheme != "https" && u.Scheme != "http") {
+			sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("redirect_uri %q must be an absolute http(s) URL without a fragment", redirectURI))
+			return
+		}
+	}
+	for _, grantType := range req.GrantTypes {
+		if grantType == auth.GrantAuthorizationCode && len(req.RedirectURIs) == 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "redirect_uris are required for the authorization_code grant")
+			return
+		}
+	}
+
+	client := &db.OAuthClient{
+		Name:         req.Name,
+		Scopes:       req.Scopes,
+		GrantTypes:   req.GrantTypes,
+		RedirectURIs: req.RedirectURIs,
+		Owner:        middleware.GetUserFromContext(r),
+	}
+	secret, err := auth.GenerateOAuthClient(client)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH CLIENTS] Failed to generate client credentials: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to generate client credentials")
+		return
+	}
+	if err := db.SaveOAuthClient(client); err != nil {
+		logger.ErrorLogger.Printf("[OAUTH CLIENTS] Failed to save client: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to register OAuth client")
+		return
+	}
+
+	logger.InfoLogger.Printf("[OAUTH CLIENTS] User %s registered client %s (%s) with scopes %v and grants %v",
+		client.Owner, client.ClientID, client.Name, client.Scopes, client.GrantTypes)
+	sendSuccessResponse(w, http.StatusCreated, "OAuth client registered; store the secret now, it will not be shown again",
+		CreatedOAuthClient{ClientSecret: secret, OAuthClient: client})
+}
+
+// HandleListOAuthClients lists the calling user's OAuth2 clients without their secrets
+func HandleListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	clients, err := db.ListOAuthClients(middleware.GetUserFromContext(r))
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH CLIENTS] Failed to list clients: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list OAuth clients")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "OAuth clients fetched successfully", clients)
+}
+
+// HandleRevokeOAuthClient revokes one of the calling user's OAuth2 clients. Tokens it already
+// issued stay valid until they expire, but it can no longer obtain or refresh tokens.
+func HandleRevokeOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	clientID := chi.URLParam(r, "client_id")
+	user := middleware.GetUserFromContext(r)
+	revoked, err := db.RevokeOAuthClient(clientID, user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[OAUTH CLIENTS] Failed to revoke client %s: %v", clientID, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke OAuth client")
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, http.StatusNotFound, "OAuth client not found or already revoked")
+		return
+	}
+
+	logger.InfoLogger.Printf("[OAUTH CLIENTS] User %s revoked client %s", user, clientID)
+	sendSuccessResponse(w, http.StatusOK, "OAuth client revoked", nil)
+}
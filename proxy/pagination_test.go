@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, id := range []int{1, 9, 50, 123456, 1<<31 - 1} {
+		cursor := encodeCursor(id)
+		got, err := decodeCursor(cursor)
+		if err != nil || got != id {
+			t.Errorf("decodeCursor(encodeCursor(%d)) = (%d, %v), want (%d, nil)", id, got, err, id)
+		}
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name    string
+		cursor  string
+		want    int
+		wantErr bool
+	}{
+		{"empty is the first page", "", 0, false},
+		{"valid", raw("42"), 42, false},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("4")), 0, true},
+		{"not base64", "!!", 0, true},
+		{"not a number", raw("abc"), 0, true},
+		{"zero", raw("0"), 0, true},
+		{"negative", raw("-5"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("decodeCursor(%q) = (%d, %v), want (%d, error %v)", tt.cursor, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"database/sql"
+	"net/http"
+
+	"rubxy/audit"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+	"rubxy/users"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// recordPayout stores the outcome of a reward transfer so it can be listed and reconciled later,
+// and adds it to the audit log; r is nil for scheduled payouts. Recording failures are logged
+// but never fail the transfer itself.
+func recordPayout(r *http.Request, payload RewardTransferRequest, requestedBy string, scheduleID *int, transfer *RewardTransferResult, transferErr error) {
+	payout := &db.Payout{
+		AdminDID:    payload.AdminDID,
+		UserDID:     payload.UserDID,
+		ActivityIDs: payload.ActivityID,
+		RequestedBy: requestedBy,
+		ScheduleID:  scheduleID,
+	}
+
+	if transferErr != nil {
+		payout.Status = db.PayoutFailed
+		payout.Message = transferErr.Error()
+		payout.HTTPStatus = http.StatusInternalServerError
+		if upstreamErr, ok := transferErr.(*UpstreamError); ok {
+			payout.HTTPStatus = upstreamErr.StatusCode
+		}
+	} else {
+		payout.Status = db.PayoutAccepted
+		payout.Message = transfer.Message
+		payout.HTTPStatus = transfer.StatusCode
+		payout.RequestID = getStringValue(transfer.Result["request_id"], "")
+		payout.TransactionID = getStringValue(transfer.Result["transaction_id"], "")
+		payout.BlockID = getStringValue(transfer.Result["block_id"], "")
+	}
+
+	if err := db.SavePayout(payout); err != nil {
+		logger.ErrorLogger.Printf("[ADMIN PAYOUTS] Failed to record payout for user %s: %v", payload.UserDID, err)
+	}
+	audit.Record(r, audit.Event{
+		Action:  audit.ActionPayout,
+		Actor:   requestedBy,
+		Target:  payload.UserDID,
+		Outcome: audit.Outcome(transferErr == nil),
+		Payload: map[string]interface{}{"request": payload, "schedule_id": scheduleID, "request_id": payout.RequestID},
+	})
+}
+
+// HandleListPayouts lists recorded payouts, filtered by admin_did, user_did, activity_id, status,
+// from and to, with cursor pagination (limit, cursor) and sort=asc|desc. Admins see every
+// payout; operators only those made under admin DIDs they own.
+func HandleListPayouts(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parsePayoutFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.AdminDID = r.URL.Query().Get("admin_did")
+	filter.UserDID = r.URL.Query().Get("user_did")
+
+	user := middleware.GetUserFromContext(r)
+	admin, err := isAdminRole(user)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT HISTORY] Failed to look up the role of %s: %v", user, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payouts")
+		return
+	}
+	if !admin {
+		if filter.AdminDID != "" {
+			if _, ok := resolveAdminDID(w, r, filter.AdminDID); !ok {
+				return
+			}
+		} else if filter.AdminDIDs, err = ownedAdminDIDs(user); err != nil {
+			logger.ErrorLogger.Printf("[PAYOUT HISTORY] Failed to list admin DIDs of %s: %v", user, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payouts")
+			return
+		}
+	}
+
+	sendPayoutPage(w, filter)
+}
+
+// HandleUserPayoutHistory lists the recorded payouts made to a single user DID. The DID's
+// owner and admins see all of them; operators see those made under admin DIDs they own.
+func HandleUserPayoutHistory(w http.ResponseWriter, r *http.Request) {
+	userDID := chi.URLParam(r, "user_did")
+	if userDID == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "user_did is required")
+		return
+	}
+	filter, ok := parsePayoutFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.UserDID = userDID
+
+	user := middleware.GetUserFromContext(r)
+	adminDIDs, err := payoutHistoryAdminDIDs(user, userDID)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT HISTORY] Failed to check access to %s: %v", userDID, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payouts")
+		return
+	}
+	if adminDIDs != nil && len(adminDIDs) == 0 {
+		sendErrorResponse(w, http.StatusForbidden, "You can only view the payout history of DIDs you own")
+		return
+	}
+	filter.AdminDIDs = adminDIDs
+
+	sendPayoutPage(w, filter)
+}
+
+// payoutHistoryAdminDIDs returns the admin DIDs whose payouts to did user may see: nil for
+// every payout if user owns did or has the admin role, otherwise the active admin DIDs user
+// owns, which is empty for users who may see none
+func payoutHistoryAdminDIDs(user, did string) ([]string, error) {
+	record, err := db.GetDID(did)
+	if err != nil {
+		return nil, err
+	}
+	if record != nil && record.Owner == user {
+		return nil, nil
+	}
+	admin, err := isAdminRole(user)
+	if err != nil || admin {
+		return nil, err
+	}
+	return ownedAdminDIDs(user)
+}
+
+// ownedAdminDIDs returns the active admin DIDs user owns, never nil
+func ownedAdminDIDs(user string) ([]string, error) {
+	owned, err := db.ListAdminDIDs(user, db.AdminDIDActive)
+	if err != nil {
+		return nil, err
+	}
+	dids := []string{}
+	for _, a := range owned {
+		dids = append(dids, a.DID)
+	}
+	return dids, nil
+}
+
+// isAdminRole reports whether user has the admin role
+func isAdminRole(user string) (bool, error) {
+	role, err := users.Role(user)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return role == users.RoleAdmin, err
+}
+
+// parsePayoutFilter reads the query parameters shared by the payout list endpoints
+func parsePayoutFilter(w http.ResponseWriter, r *http.Request) (db.PayoutFilter, bool) {
+	query := r.URL.Query()
+	filter := db.PayoutFilter{
+		ActivityID: query.Get("activity_id"),
+		Status:     query.Get("status"),
+	}
+
+	if filter.Status != "" && filter.Status != db.PayoutAccepted && filter.Status != db.PayoutFailed {
+		sendErrorResponse(w, http.StatusBadRequest, "status must be accepted or failed")
+		return filter, false
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(r, "from"); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+	if filter.To, err = parseTimeParam(r, "to"); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+	if filter.AfterID, err = decodeCursor(query.Get("cursor")); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+	if filter.Ascending, err = parseSortAscending(r); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+	return filter, true
+}
+
+func sendPayoutPage(w http.ResponseWriter, filter db.PayoutFilter) {
+	payouts, err := db.ListPayouts(filter)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT HISTORY] Failed to list payouts: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payouts")
+		return
+	}
+
+	page := Page{Items: payouts}
+	if len(payouts) == filter.Limit {
+		page.NextCursor = encodeCursor(payouts[len(payouts)-1].ID)
+	}
+	sendSuccessResponse(w, http.StatusOK, "Payouts fetched successfully", page)
+}
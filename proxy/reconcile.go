@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Reconciliation mismatch kinds
+const (
+	MismatchMissingTransactionID = "missing_transaction_id"
+	MismatchFailedAfterAccept    = "failed_after_accept"
+	MismatchStatusUnavailable    = "status_unavailable"
+	MismatchUnexpectedBalance    = "unexpected_balance"
+	MismatchUnattributedBalance  = "unattributed_balance"
+)
+
+type ReconciliationRequest struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+type FTInfo struct {
+	FTName     string `json:"ft_name"`
+	FTCount    int    `json:"ft_count"`
+	CreatorDID string `json:"creator_did"`
+}
+
+type FTInfoResponse struct {
+	Status  bool     `json:"status"`
+	Message string   `json:"message"`
+	FTInfo  []FTInfo `json:"ft_info"`
+}
+
+// HandleStartReconciliation starts a reconciliation of accepted payouts in a period (default: the last 7 days)
+// and returns the report, which is filled in as the job runs
+func HandleStartReconciliation(w http.ResponseWriter, r *http.Request) {
+	var req ReconciliationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	to := time.Now()
+	if req.To != nil {
+		to = *req.To
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if req.From != nil {
+		from = *req.From
+	}
+	if !from.Before(to) {
+		sendErrorResponse(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	report := &db.ReconciliationReport{
+		PeriodFrom:  from,
+		PeriodTo:    to,
+		RequestedBy: middleware.GetUserFromContext(r),
+	}
+	if err := db.CreateReconciliationReport(report); err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to create report: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to start reconciliation")
+		return
+	}
+
+	logger.InfoLogger.Printf("[RECONCILIATION] User %s started report %d for %s - %s",
+		report.RequestedBy, report.ID, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	go runReconciliation(*report)
+
+	sendSuccessResponse(w, http.StatusAccepted, "Reconciliation started", report)
+}
+
+// HandleListReconciliations lists reconciliation report summaries
+func HandleListReconciliations(w http.ResponseWriter, r *http.Request) {
+	reports, err := db.ListReconciliationReports()
+	if err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to list reports: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list reconciliation reports")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Reconciliation reports fetched successfully", reports)
+}
+
+// HandleGetReconciliation returns a report with its mismatches as JSON, or the mismatches as CSV with ?format=csv
+func HandleGetReconciliation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid report id")
+		return
+	}
+
+	report, err := db.GetReconciliationReport(id)
+	if err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to fetch report %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reconciliation report")
+		return
+	}
+	if report == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Reconciliation report not found")
+		return
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		sendSuccessResponse(w, http.StatusOK, "Reconciliation report fetched successfully", report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="reconciliation-%d.csv"`, report.ID))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"report_id", "payout_id", "user_did", "kind", "detail", "expected", "actual"})
+	for _, m := range report.Mismatches {
+		payoutID := ""
+		if m.PayoutID != nil {
+			payoutID = strconv.Itoa(*m.PayoutID)
+		}
+		cw.Write(escapeCSVRecord([]string{strconv.Itoa(m.ReportID), payoutID, m.UserDID, m.Kind, m.Detail, m.Expected, m.Actual}))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to write CSV for report %d: %v", report.ID, err)
+	}
+}
+
+// runReconciliation checks every accepted payout in the report period against the status API,
+// then compares each affected user's expected reward total with their FT balance on the node
+func runReconciliation(report db.ReconciliationReport) {
+	payouts, err := db.AcceptedPayoutsBetween(report.PeriodFrom, report.PeriodTo)
+	if err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Report %d failed to load payouts: %v", report.ID, err)
+		finishReconciliation(report.ID, db.ReconciliationFailed, 0, 0, err.Error())
+		return
+	}
+
+	mismatches := 0
+	addMismatch := func(m db.ReconciliationMismatch) {
+		m.ReportID = report.ID
+		if err := db.SaveReconciliationMismatch(&m); err != nil {
+			logger.ErrorLogger.Printf("[RECONCILIATION] Report %d failed to save mismatch: %v", report.ID, err)
+			return
+		}
+		mismatches++
+	}
+
+	// payoutStates holds the status API outcome of each payout checked so far
+	payoutStates := make(map[int]string)
+	userDIDs := []string{}
+	seenUsers := make(map[string]bool)
+
+	for _, payout := range payouts {
+		if !seenUsers[payout.UserDID] {
+			seenUsers[payout.UserDID] = true
+			userDIDs = append(userDIDs, payout.UserDID)
+		}
+
+		payoutID := payout.ID
+		if payout.RequestID == "" {
+			if payout.TransactionID == "" {
+				addMismatch(db.ReconciliationMismatch{
+					PayoutID: &payoutID,
+					UserDID:  payout.UserDID,
+					Kind:     MismatchMissingTransactionID,
+					Detail:   "Payout has neither a request_id nor a transaction_id",
+				})
+			}
+			continue
+		}
+
+		apiResp, err := FetchPayoutStatus(payout.RequestID)
+		if err != nil {
+			addMismatch(db.ReconciliationMismatch{
+				PayoutID: &payoutID,
+				UserDID:  payout.UserDID,
+				Kind:     MismatchStatusUnavailable,
+				Detail:   err.Error(),
+			})
+			continue
+		}
+
+		state, transactionID := payoutStatusOutcome(apiResp)
+		payoutStates[payout.ID] = state
+		switch state {
+		case "failed":
+			addMismatch(db.ReconciliationMismatch{
+				PayoutID: &payoutID,
+				UserDID:  payout.UserDID,
+				Kind:     MismatchFailedAfterAccept,
+				Detail:   fmt.Sprintf("Request %s was accepted but the status API reports it failed", payout.RequestID),
+				Expected: "success",
+				Actual:   "failed",
+			})
+		case "success":
+			if transactionID == "" && payout.TransactionID == "" {
+				addMismatch(db.ReconciliationMismatch{
+					PayoutID: &payoutID,
+					UserDID:  payout.UserDID,
+					Kind:     MismatchMissingTransactionID,
+					Detail:   fmt.Sprintf("Request %s completed without a transaction_id", payout.RequestID),
+				})
+			}
+		}
+	}
+
+	for _, userDID := range userDIDs {
+		if m := checkUserBalance(userDID, payoutStates); m != nil {
+			addMismatch(*m)
+		}
+	}
+
+	logger.InfoLogger.Printf("[RECONCILIATION] Report %d completed: %d payouts checked, %d mismatches", report.ID, len(payouts), mismatches)
+	finishReconciliation(report.ID, db.ReconciliationCompleted, len(payouts), mismatches, "")
+}
+
+func finishReconciliation(id int, status string, payoutsChecked, mismatches int, errMsg string) {
+	if err := db.FinishReconciliationReport(id, status, payoutsChecked, mismatches, errMsg); err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to finish report %d: %v", id, err)
+	}
+}
+
+// checkUserBalance compares the reward points a user should have received across all accepted payouts
+// with the FT balance the node reports for tokens created by the paying admin DIDs. The balance covers
+// every payout ever made to the user, so payouts outside the report period are checked against the
+// status API too and their outcome is added to payoutStates.
+func checkUserBalance(userDID string, payoutStates map[int]string) *db.ReconciliationMismatch {
+	payouts, err := db.AcceptedPayoutsForUser(userDID)
+	if err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to load payouts for %s: %v", userDID, err)
+		return nil
+	}
+
+	activityIDs := []string{}
+	adminDIDs := make(map[string]bool)
+	counted := 0
+	for _, p := range payouts {
+		state, checked := payoutStates[p.ID]
+		if !checked && p.RequestID != "" {
+			apiResp, err := FetchPayoutStatus(p.RequestID)
+			if err != nil {
+				return &db.ReconciliationMismatch{
+					UserDID: userDID,
+					Kind:    MismatchStatusUnavailable,
+					Detail:  fmt.Sprintf("Balance not checked: status of payout %d is unavailable: %v", p.ID, err),
+				}
+			}
+			state, _ = payoutStatusOutcome(apiResp)
+			payoutStates[p.ID] = state
+		}
+		if state == "failed" {
+			continue
+		}
+		counted++
+		activityIDs = append(activityIDs, p.ActivityIDs...)
+		adminDIDs[p.AdminDID] = true
+	}
+
+	points, err := db.ActivityRewardPoints(activityIDs)
+	if err != nil {
+		logger.ErrorLogger.Printf("[RECONCILIATION] Failed to load reward points for %s: %v", userDID, err)
+		return nil
+	}
+
+	expected := 0
+	unknown := 0
+	for _, id := range activityIDs {
+		p, ok := points[id]
+		if !ok {
+			unknown++
+			continue
+		}
+		expected += p
+	}
+	if unknown > 0 {
+		// Without reward points for every activity the expected balance is meaningless
+		logger.InfoLogger.Printf("[RECONCILIATION] Skipping balance check for %s: %d activities not recorded in Rubxy", userDID, unknown)
+		return nil
+	}
+
+	ftInfo, err := fetchFTInfo(userDID)
+	if err != nil {
+		return &db.ReconciliationMismatch{
+			UserDID: userDID,
+			Kind:    MismatchStatusUnavailable,
+			Detail:  fmt.Sprintf("Failed to fetch FT info: %v", err),
+		}
+	}
+
+	// Tokens without a creator cannot be traced to a payout, so they are reported rather than
+	// counted towards the expected balance
+	actual, unattributed := 0, 0
+	for _, ft := range ftInfo {
+		switch {
+		case ft.CreatorDID == "":
+			unattributed += ft.FTCount
+		case adminDIDs[ft.CreatorDID]:
+			actual += ft.FTCount
+		}
+	}
+
+	if actual == expected {
+		if unattributed > 0 {
+			return &db.ReconciliationMismatch{
+				UserDID:  userDID,
+				Kind:     MismatchUnattributedBalance,
+				Detail:   fmt.Sprintf("The node reports %d tokens with no creator DID, which no recorded payout explains", unattributed),
+				Expected: "0",
+				Actual:   strconv.Itoa(unattributed),
+			}
+		}
+		return nil
+	}
+	detail := fmt.Sprintf("FT balance differs from the %d accepted payouts recorded for this user", counted)
+	if unattributed > 0 {
+		detail += fmt.Sprintf("; %d further tokens have no creator DID", unattributed)
+	}
+	return &db.ReconciliationMismatch{
+		UserDID:  userDID,
+		Kind:     MismatchUnexpectedBalance,
+		Detail:   detail,
+		Expected: strconv.Itoa(expected),
+		Actual:   strconv.Itoa(actual),
+	}
+}
+
+// payoutStatusOutcome maps a status API response to "success", "failed" or "pending",
+// along with any transaction_id it reports
+func payoutStatusOutcome(apiResp map[string]interface{}) (string, string) {
+	data, _ := apiResp["data"].(map[string]interface{})
+	transactionID := getStringValue(data["transaction_id"], "")
+
+	switch strings.ToLower(getStringValue(data["status"], "")) {
+	case "success", "completed", "confirmed", "done":
+		return "success", transactionID
+	case "failed", "failure", "error", "rejected":
+		return "failed", transactionID
+	}
+	return "pending", transactionID
+}
+
+// fetchFTInfo returns the fungible token holdings the node reports for a DID
+func fetchFTInfo(did string) ([]FTInfo, error) {
+	targetURL := fmt.Sprintf("http://localhost:20050/api/get-ft-info-by-did?did=%s", url.QueryEscape(did))
+
+	resp, err := SharedHTTPClient.Get(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("node returned status %d", resp.StatusCode)
+	}
+
+	var ftResp FTInfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ftResp); err != nil {
+		return nil, fmt.Errorf("failed to parse FT info: %w", err)
+	}
+	if !ftResp.Status {
+		return nil, fmt.Errorf("node returned failure: %s", ftResp.Message)
+	}
+	return ftResp.FTInfo, nil
+}
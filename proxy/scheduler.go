@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"rubxy/db"
+	"rubxy/logger"
+
+	"github.com/robfig/cron/v3"
+)
+
+// RunPayoutScheduler executes due payout schedules every interval until ctx is cancelled.
+// Only the instance holding the scheduler advisory lock runs schedules on a given tick,
+// so it is safe to run several Rubxy instances against the same database.
+func RunPayoutScheduler(ctx context.Context, interval time.Duration) {
+	logger.InfoLogger.Printf("[PAYOUT SCHEDULER] Started with interval %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.InfoLogger.Println("[PAYOUT SCHEDULER] Stopped")
+			return
+		case <-ticker.C:
+			// Instances that lose the lock simply skip this tick
+			if _, err := db.WithAdvisoryLock(ctx, db.PayoutSchedulerLockKey, runDuePayoutSchedules); err != nil {
+				logger.ErrorLogger.Printf("[PAYOUT SCHEDULER] Tick failed: %v", err)
+			}
+		}
+	}
+}
+
+func runDuePayoutSchedules() error {
+	// No run is in progress while we hold the lock, so any left running belong to a crashed instance
+	if interrupted, err := db.FailInterruptedPayoutScheduleRuns(); err != nil {
+		return err
+	} else if interrupted > 0 {
+		logger.InfoLogger.Printf("[PAYOUT SCHEDULER] Marked %d interrupted runs as failed", interrupted)
+	}
+
+	now := time.Now()
+	schedules, err := db.DuePayoutSchedules(now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		runPayoutSchedule(schedule, now)
+	}
+	return nil
+}
+
+// runPayoutSchedule advances the schedule before transferring, so a crash mid-transfer
+// can skip a run but never pay the same run twice
+func runPayoutSchedule(schedule db.PayoutSchedule, now time.Time) {
+	scheduledFor := *schedule.NextRunAt
+
+	var next *time.Time
+	if schedule.CronExpr != "" {
+		parsed, err := cron.ParseStandard(schedule.CronExpr)
+		if err != nil {
+			logger.ErrorLogger.Printf("[PAYOUT SCHEDULER] Schedule %d has invalid cron expression %q: %v", schedule.ID, schedule.CronExpr, err)
+		} else {
+			n := parsed.Next(now)
+			next = &n
+		}
+	}
+	if err := db.AdvancePayoutSchedule(schedule.ID, next); err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULER] Failed to advance schedule %d, not running it: %v", schedule.ID, err)
+		return
+	}
+
+	runID, err := db.StartPayoutScheduleRun(schedule.ID, scheduledFor)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULER] Failed to record run for schedule %d: %v", schedule.ID, err)
+		return
+	}
+
+	logger.InfoLogger.Printf("[PAYOUT SCHEDULER] Running schedule %d (run %d) - ActivityID: %v, UserDID: %s, AdminDID: %s",
+		schedule.ID, runID, schedule.ActivityIDs, schedule.UserDID, schedule.AdminDID)
+
+	payload := RewardTransferRequest{
+		ActivityID: schedule.ActivityIDs,
+		UserDID:    schedule.UserDID,
+		AdminDID:   schedule.AdminDID,
+	}
+
+	// The admin DID may have been revoked or handed over since the schedule was created
+	var transfer *RewardTransferResult
+	err = checkAdminDID(schedule.AdminDID, schedule.CreatedBy)
+	if err == nil {
+		transfer, err = TransferRewards(payload)
+		recordPayout(nil, payload, schedule.CreatedBy, &schedule.ID, transfer, err)
+	}
+
+	status, message, result := db.ScheduleRunSucceeded, "", ""
+	if err != nil {
+		status, message = db.ScheduleRunFailed, err.Error()
+	} else {
+		message = transfer.Message
+		if encoded, err := json.Marshal(transfer.Result); err == nil {
+			result = string(encoded)
+		}
+	}
+
+	if err := db.FinishPayoutScheduleRun(runID, status, message, result); err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULER] Failed to record outcome of run %d: %v", runID, err)
+	}
+	logger.InfoLogger.Printf("[PAYOUT SCHEDULER] Schedule %d run %d finished with status %s: %s", schedule.ID, runID, status, message)
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/robfig/cron/v3"
+)
+
+type PayoutScheduleRequest struct {
+	Name       string     `json:"name"`
+	AdminDID   string     `json:"admin_did"`
+	UserDID    string     `json:"user_did"`
+	ActivityID []string   `json:"activity_id"`
+	RunAt      *time.Time `json:"run_at"`
+	Cron       string     `json:"cron"`
+}
+
+// HandleCreatePayoutSchedule creates a one-off (run_at) or recurring (cron) payout schedule
+func HandleCreatePayoutSchedule(w http.ResponseWriter, r *http.Request) {
+	var req PayoutScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.UserDID == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "user_did is required")
+		return
+	}
+	if len(req.ActivityID) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "activity_id array cannot be empty")
+		return
+	}
+	if (req.RunAt == nil) == (req.Cron == "") {
+		sendErrorResponse(w, http.StatusBadRequest, "exactly one of run_at or cron is required")
+		return
+	}
+	adminDID, ok := resolveAdminDID(w, r, req.AdminDID)
+	if !ok {
+		return
+	}
+	req.AdminDID = adminDID
+
+	schedule := &db.PayoutSchedule{
+		Name:        req.Name,
+		AdminDID:    req.AdminDID,
+		UserDID:     req.UserDID,
+		ActivityIDs: req.ActivityID,
+		CreatedBy:   middleware.GetUserFromContext(r),
+	}
+
+	if req.RunAt != nil {
+		if !req.RunAt.After(time.Now()) {
+			sendErrorResponse(w, http.StatusBadRequest, "run_at must be in the future")
+			return
+		}
+		schedule.RunAt = req.RunAt
+		schedule.NextRunAt = req.RunAt
+	} else {
+		parsed, err := cron.ParseStandard(req.Cron)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid cron expression: "+err.Error())
+			return
+		}
+		next := parsed.Next(time.Now())
+		schedule.CronExpr = req.Cron
+		schedule.NextRunAt = &next
+	}
+
+	if err := db.CreatePayoutSchedule(schedule); err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to create schedule: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to create payout schedule")
+		return
+	}
+
+	logger.InfoLogger.Printf("[PAYOUT SCHEDULES] User %s created schedule %d (next run: %v)",
+		schedule.CreatedBy, schedule.ID, schedule.NextRunAt)
+	sendSuccessResponse(w, http.StatusCreated, "Payout schedule created", schedule)
+}
+
+// HandleListPayoutSchedules lists the payout schedules the caller created or pays from
+func HandleListPayoutSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := db.ListPayoutSchedules(middleware.GetUserFromContext(r))
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to list schedules: %v", err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payout schedules")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Payout schedules fetched successfully", schedules)
+}
+
+// HandleGetPayoutSchedule returns a single payout schedule
+func HandleGetPayoutSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := scheduleParam(w, r)
+	if !ok {
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Payout schedule fetched successfully", schedule)
+}
+
+// HandleCancelPayoutSchedule disables a schedule so it does not run again; its history is kept
+func HandleCancelPayoutSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := scheduleParam(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := db.DisablePayoutSchedule(schedule.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to cancel schedule %d: %v", schedule.ID, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to cancel payout schedule")
+		return
+	}
+	if !found {
+		sendErrorResponse(w, http.StatusNotFound, "Payout schedule not found")
+		return
+	}
+
+	logger.InfoLogger.Printf("[PAYOUT SCHEDULES] User %s cancelled schedule %d", middleware.GetUserFromContext(r), schedule.ID)
+	sendSuccessResponse(w, http.StatusOK, "Payout schedule cancelled", nil)
+}
+
+// HandleListPayoutScheduleRuns returns the outcome of each run of a schedule
+func HandleListPayoutScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := scheduleParam(w, r)
+	if !ok {
+		return
+	}
+
+	runs, err := db.ListPayoutScheduleRuns(schedule.ID)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to list runs for schedule %d: %v", schedule.ID, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list payout schedule runs")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Payout schedule runs fetched successfully", runs)
+}
+
+// scheduleParam returns the schedule named by the {id} URL parameter if the caller created it or
+// can manage the admin DID it pays from, writing an error response otherwise
+func scheduleParam(w http.ResponseWriter, r *http.Request) (*db.PayoutSchedule, bool) {
+	id, ok := scheduleIDParam(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	schedule, err := db.GetPayoutSchedule(id)
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to fetch schedule %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch payout schedule")
+		return nil, false
+	}
+	if schedule == nil {
+		sendErrorResponse(w, http.StatusNotFound, "Payout schedule not found")
+		return nil, false
+	}
+
+	user := middleware.GetUserFromContext(r)
+	if schedule.CreatedBy == user {
+		return schedule, true
+	}
+	admin, err := db.GetAdminDID(schedule.AdminDID)
+	allowed := false
+	if err == nil && admin != nil {
+		allowed, err = canManageAdminDID(admin, user)
+	}
+	if err != nil {
+		logger.ErrorLogger.Printf("[PAYOUT SCHEDULES] Failed to look up admin DID of schedule %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch payout schedule")
+		return nil, false
+	}
+	if !allowed {
+		// Not found rather than forbidden, so schedule IDs cannot be probed
+		sendErrorResponse(w, http.StatusNotFound, "Payout schedule not found")
+		return nil, false
+	}
+	return schedule, true
+}
+
+// scheduleIDParam parses the {id} URL parameter, writing an error response if it is invalid
+func scheduleIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid schedule id")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"rubxy/auth"
+	"rubxy/config"
+	"rubxy/db"
+	"rubxy/logger"
+	"rubxy/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionInfo is a session as shown to its owner; Current marks the session the request was made with
+type SessionInfo struct {
+	db.Session
+	Current bool `json:"current"`
+}
+
+type RevokeAllSessionsRequest struct {
+	// IncludeCurrent also ends the session the request was made with
+	IncludeCurrent bool `json:"include_current"`
+}
+
+// sessionIDParam parses the {id} URL parameter, writing a 400 if it is not a session ID
+func sessionIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid session id")
+		return 0, false
+	}
+	return id, true
+}
+
+// revokeSession ends one of username's sessions and its access tokens, writing the response
+func revokeSession(w http.ResponseWriter, r *http.Request, cfg *config.Config, username string, id int64) {
+	revoked, err := db.RevokeSession(id, username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[SESSIONS] Failed to revoke session %d of %s: %v", id, username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if !revoked {
+		sendErrorResponse(w, http.StatusNotFound, "Session not found or already revoked")
+		return
+	}
+	if err := auth.RevokeSessionAccess(cfg, username, id); err != nil {
+		logger.ErrorLogger.Printf("[SESSIONS] Failed to revoke access tokens of session %d: %v", id, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Session revoked, but its access tokens could not be")
+		return
+	}
+
+	logger.InfoLogger.Printf("[SESSIONS] User %s revoked session %d of %s", middleware.GetUserFromContext(r), id, username)
+	sendSuccessResponse(w, http.StatusOK, "Session revoked", nil)
+}
+
+// HandleListMySessions lists the caller's active sessions
+func HandleListMySessions(w http.ResponseWriter, r *http.Request) {
+	if rejectDelegatedRequest(w, r) {
+		return
+	}
+
+	username := middleware.GetUserFromContext(r)
+	sessions, err := db.ListSessions(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[SESSIONS] Failed to list sessions of %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	current := middleware.GetSessionIDFromContext(r)
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, SessionInfo{Session: s, Current: current != 0 && s.ID == current})
+	}
+	sendSuccessResponse(w, http.StatusOK, "Sessions fetched successfully", infos)
+}
+
+// HandleRevokeMySession ends one of the caller's sessions, such as a login on a lost device
+func HandleRevokeMySession(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectDelegatedRequest(w, r) {
+			return
+		}
+		id, ok := sessionIDParam(w, r)
+		if !ok {
+			return
+		}
+		revokeSession(w, r, cfg, middleware.GetUserFromContext(r), id)
+	}
+}
+
+// HandleRevokeAllMySessions ends all of the caller's other sessions, and the current one too if
+// include_current is set
+func HandleRevokeAllMySessions(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rejectDelegatedRequest(w, r) {
+			return
+		}
+
+		var req RevokeAllSessionsRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				sendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		username := middleware.GetUserFromContext(r)
+		keep := middleware.GetSessionIDFromContext(r)
+		if req.IncludeCurrent {
+			keep = 0
+		}
+		revoked, err := auth.RevokeUserSessions(cfg, username, keep)
+		if err != nil {
+			logger.ErrorLogger.Printf("[SESSIONS] Failed to revoke sessions of %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+
+		logger.InfoLogger.Printf("[SESSIONS] User %s revoked %d of their sessions", username, len(revoked))
+		sendSuccessResponse(w, http.StatusOK, "Sessions revoked", map[string]int{"revoked_sessions": len(revoked)})
+	}
+}
+
+// HandleListUserSessions lists a user's active sessions for an admin
+func HandleListUserSessions(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminRole(w, r) {
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	sessions, err := db.ListSessions(username)
+	if err != nil {
+		logger.ErrorLogger.Printf("[SESSIONS] Failed to list sessions of %s: %v", username, err)
+		sendErrorResponse(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+	sendSuccessResponse(w, http.StatusOK, "Sessions fetched successfully", sessions)
+}
+
+// HandleRevokeUserSession ends one session of a user
+func HandleRevokeUserSession(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminRole(w, r) {
+			return
+		}
+		id, ok := sessionIDParam(w, r)
+		if !ok {
+			return
+		}
+		revokeSession(w, r, cfg, chi.URLParam(r, "username"), id)
+	}
+}
+
+// HandleRevokeUserSessions force-logs-out a user by ending all of their sessions
+func HandleRevokeUserSessions(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdminRole(w, r) {
+			return
+		}
+
+		username := chi.URLParam(r, "username")
+		revoked, err := auth.RevokeUserSessions(cfg, username, 0)
+		if err != nil {
+			logger.ErrorLogger.Printf("[SESSIONS] Failed to revoke sessions of %s: %v", username, err)
+			sendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+
+		logger.InfoLogger.Printf("[SESSIONS] User %s logged out %s, %d sessions revoked",
+			middleware.GetUserFromContext(r), username, len(revoked))
+		sendSuccessResponse(w, http.StatusOK, "Sessions revoked", map[string]int{"revoked_sessions": len(revoked)})
+	}
+}
@@ -1,11 +1,19 @@
 package users
 
 import (
+	"database/sql"
 	"fmt"
 	"rubxy/db"
+	"rubxy/logger"
 	"sync"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
+)
+
+// Roles a user can have
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
 )
 
 type User struct {
@@ -18,29 +26,86 @@ var (
 	mu    sync.Mutex
 )
 
-func Register(username, password string) error {
+// Authenticate checks a user's password; disabled accounts never pass. A stored hash made with
+// an older algorithm or older parameters is replaced with a current one after a successful check.
+func Authenticate(username, password string) bool {
+	var hashed string
+	var disabled bool
+	err := db.DB.QueryRow("SELECT password_hash, disabled_at IS NOT NULL FROM users WHERE username=$1", username).
+		Scan(&hashed, &disabled)
+	if err != nil {
+		verifyPassword(dummyHash, password)
+		return false
+	}
+
+	ok, rehash := verifyPassword(hashed, password)
+	if ok && disabled {
+		logger.InfoLogger.Printf("Login refused for disabled user %s", username)
+		return false
+	}
+	if ok && rehash {
+		upgradeHash(username, hashed, password)
+	}
+	return ok
+}
 
-	//return fmt.Errorf("user registration is currently disabled: no more users are allowed to create")
+// upgradeHash rehashes a password with the current parameters, unless the stored hash changed meanwhile
+func upgradeHash(username, oldHash, password string) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to rehash password for %s: %v", username, err)
+		return
+	}
+	_, err = db.DB.Exec("UPDATE users SET password_hash=$3 WHERE username=$1 AND password_hash=$2", username, oldHash, hash)
+	if err != nil {
+		logger.ErrorLogger.Printf("Failed to store rehashed password for %s: %v", username, err)
+		return
+	}
+	logger.InfoLogger.Printf("Upgraded password hash for %s", username)
+}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// SetPassword replaces a user's password; a password the policy rejects returns a *PolicyError
+func SetPassword(username, password string) error {
+	if err := ValidatePassword(username, password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
 	if err != nil {
 		return fmt.Errorf("password hash error: %w", err)
 	}
 
-	_, err = db.DB.Exec("INSERT INTO users (username, password_hash) VALUES ($1, $2)", username, string(hash))
+	res, err := db.DB.Exec("UPDATE users SET password_hash=$2 WHERE username=$1", username, hash)
 	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
 	}
 	return nil
 }
 
-func Authenticate(username, password string) bool {
-	var hashed string
-	err := db.DB.QueryRow("SELECT password_hash FROM users WHERE username=$1", username).Scan(&hashed)
-	if err != nil {
-		return false
-	}
+// Email returns a user's email address, which may be empty
+func Email(username string) (string, error) {
+	var email string
+	err := db.DB.QueryRow("SELECT email FROM users WHERE username=$1", username).Scan(&email)
+	return email, err
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
-	return err == nil
+// Role returns the role of a user
+func Role(username string) (string, error) {
+	var role string
+	err := db.DB.QueryRow("SELECT role FROM users WHERE username=$1", username).Scan(&role)
+	return role, err
+}
+
+// PromoteAdmins gives the admin role to the listed users. Users that do not exist yet are
+// skipped; nobody is demoted.
+func PromoteAdmins(usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	_, err := db.DB.Exec("UPDATE users SET role=$1 WHERE username = ANY($2)", RoleAdmin, pq.Array(usernames))
+	return err
 }
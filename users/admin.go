@@ -0,0 +1,127 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"rubxy/db"
+)
+
+var ErrInvalidRole = errors.New("role must be user or admin")
+
+// lockedPasswordHash matches no password. It replaces the hash of an account whose password
+// an admin reset, so only a reset token can set a new one.
+const lockedPasswordHash = "!locked"
+
+// IsDisabled reports whether a user's account has been disabled. Users that do not exist, such
+// as deleted accounts, count as disabled so their remaining credentials are refused.
+func IsDisabled(username string) (bool, error) {
+	var disabled bool
+	err := db.DB.QueryRow("SELECT disabled_at IS NOT NULL FROM users WHERE username=$1", username).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return disabled, err
+}
+
+// SetDisabled disables or re-enables an account; it returns false if the user does not exist
+func SetDisabled(username string, disabled bool) (bool, error) {
+	query := "UPDATE users SET disabled_at = NULL WHERE username=$1"
+	args := []interface{}{username}
+	if disabled {
+		// Disabling an already disabled account keeps the original time
+		query = "UPDATE users SET disabled_at = COALESCE(disabled_at, $2) WHERE username=$1"
+		args = append(args, time.Now())
+	}
+	res, err := db.DB.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetRole changes the role of a user; it returns false if the user does not exist
+func SetRole(username, role string) (bool, error) {
+	if role != RoleUser && role != RoleAdmin {
+		return false, ErrInvalidRole
+	}
+	res, err := db.DB.Exec("UPDATE users SET role=$2 WHERE username=$1", username, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// LockPassword makes a user's current password stop working until it is reset; it returns
+// false if the user does not exist
+func LockPassword(username string) (bool, error) {
+	res, err := db.DB.Exec("UPDATE users SET password_hash=$2 WHERE username=$1", username, lockedPasswordHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Delete removes an account together with its pending tokens and codes. Its sessions, API keys,
+// OAuth2 clients and admin DIDs are revoked rather than deleted, so they stay visible in listings.
+// It returns the IDs of the revoked sessions, or found=false if the user does not exist.
+func Delete(username, deletedBy string) (sessions []int64, found bool, err error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM users WHERE username=$1", username)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	rows, err := tx.Query(`UPDATE refresh_tokens SET revoked = TRUE
+		WHERE username = $1 AND revoked = FALSE AND expires_at > $2 RETURNING id`, username, now)
+	if err != nil {
+		return nil, false, err
+	}
+	sessions = []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		sessions = append(sessions, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	statements := []string{
+		"UPDATE api_keys SET revoked_at = $2 WHERE username = $1 AND revoked_at IS NULL",
+		"UPDATE oauth_clients SET revoked_at = $2 WHERE owner = $1 AND revoked_at IS NULL",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, username, now); err != nil {
+			return nil, false, err
+		}
+	}
+	_, err = tx.Exec("UPDATE admin_dids SET status = $3, revoked_at = $2, revoked_by = $4 WHERE owner = $1 AND status = $5",
+		username, now, db.AdminDIDRevoked, deletedBy, db.AdminDIDActive)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, table := range []string{"recovery_codes", "password_resets", "email_verifications", "oauth_codes"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE username = $1", username); err != nil {
+			return nil, false, err
+		}
+	}
+	return sessions, true, tx.Commit()
+}
@@ -0,0 +1,287 @@
+package users
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"rubxy/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+	// argon2MaxMemory (KiB) bounds what a stored hash can make a login allocate
+	argon2MaxMemory = 1 << 20
+	// bcryptMaxBytes is the longest password bcrypt can hash
+	bcryptMaxBytes = 72
+)
+
+// HashParams are the parameters new password hashes are created with. Stored hashes made
+// with anything else are rehashed on the next successful login.
+type HashParams struct {
+	Algorithm     string
+	Argon2Memory  uint32 // KiB
+	Argon2Time    uint32
+	Argon2Threads uint8
+	BcryptCost    int
+}
+
+// Policy is what a new password has to satisfy
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// Breached holds the upper-case hex SHA-1 of known breached passwords
+	Breached map[string]struct{}
+}
+
+// PolicyError explains why a username, password or email address was rejected. The message
+// is meant to be shown to the user, so Field is capitalized.
+type PolicyError struct {
+	Field  string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+var (
+	hashParams = HashParams{Algorithm: HashArgon2id, Argon2Memory: 19 * 1024, Argon2Time: 2, Argon2Threads: 1, BcryptCost: bcrypt.DefaultCost}
+	policy     = Policy{MinLength: 8, MaxLength: 128}
+)
+
+// Configure sets the registration mode, password policy and hashing parameters from cfg and
+// loads the breached password list, if one is configured
+func Configure(cfg *config.Config) error {
+	params := HashParams{
+		Algorithm:     cfg.PasswordHash,
+		Argon2Memory:  cfg.Argon2Memory,
+		Argon2Time:    cfg.Argon2Time,
+		Argon2Threads: cfg.Argon2Threads,
+		BcryptCost:    cfg.BcryptCost,
+	}
+	switch {
+	case params.Algorithm != HashArgon2id && params.Algorithm != HashBcrypt:
+		return fmt.Errorf("unknown password hash %q, expected %s or %s", params.Algorithm, HashArgon2id, HashBcrypt)
+	case params.Algorithm == HashArgon2id && validArgon2Params(params.Argon2Memory, params.Argon2Time, params.Argon2Threads) != nil:
+		return fmt.Errorf("invalid argon2id parameters m=%d t=%d p=%d", params.Argon2Memory, params.Argon2Time, params.Argon2Threads)
+	case params.Algorithm == HashBcrypt && (params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost):
+		return fmt.Errorf("invalid bcrypt cost %d", params.BcryptCost)
+	}
+
+	p := Policy{MinLength: cfg.PasswordMinLength, MaxLength: cfg.PasswordMaxLength}
+	if p.MinLength < 1 || p.MaxLength < p.MinLength {
+		return fmt.Errorf("invalid password length limits %d-%d", p.MinLength, p.MaxLength)
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := loadBreachedPasswords(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return fmt.Errorf("load breached passwords: %w", err)
+		}
+		p.Breached = breached
+	}
+
+	dummy, err := hashWith(params, dummyPassword)
+	if err != nil {
+		return fmt.Errorf("hash dummy password: %w", err)
+	}
+	hashParams, policy, dummyHash = params, p, dummy
+	return configureRegistration(cfg)
+}
+
+// loadBreachedPasswords reads one password per line. Lines that are a SHA-1 hex digest,
+// optionally followed by :count as in the Have I Been Pwned downloads, are taken as hashes.
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); len(digest) == 40 {
+			if _, err := hex.DecodeString(digest); err == nil {
+				breached[strings.ToUpper(digest)] = struct{}{}
+				continue
+			}
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	return breached, scanner.Err()
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ValidatePassword checks a new password for username against the policy and returns a
+// *PolicyError if it is not acceptable
+func ValidatePassword(username, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		return &PolicyError{Field: "Password", Reason: fmt.Sprintf("must be at least %d characters", policy.MinLength)}
+	}
+	if length > policy.MaxLength {
+		return &PolicyError{Field: "Password", Reason: fmt.Sprintf("must be at most %d characters", policy.MaxLength)}
+	}
+	if hashParams.Algorithm == HashBcrypt && len(password) > bcryptMaxBytes {
+		return &PolicyError{Field: "Password", Reason: fmt.Sprintf("must be at most %d bytes", bcryptMaxBytes)}
+	}
+	if similarToUsername(username, password) {
+		return &PolicyError{Field: "Password", Reason: "must not contain or resemble the username"}
+	}
+	if _, ok := policy.Breached[sha1Hex(password)]; ok {
+		return &PolicyError{Field: "Password", Reason: "appears in a list of breached passwords, choose another one"}
+	}
+	return nil
+}
+
+// similarToUsername reports whether, ignoring case and punctuation, the password contains the
+// username forwards or backwards, or is part of it
+func similarToUsername(username, password string) bool {
+	u, p := foldAlnum(username), foldAlnum(password)
+	if len(u) < 3 || p == "" {
+		return false
+	}
+	return strings.Contains(p, u) || strings.Contains(p, reverse(u)) || strings.Contains(u, p)
+}
+
+func foldAlnum(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// hashPassword hashes a password with the configured algorithm. Argon2id hashes use the PHC
+// string format: $argon2id$v=19$m=<KiB>,t=<time>,p=<threads>$<salt>$<hash>.
+func hashPassword(password string) (string, error) {
+	return hashWith(hashParams, password)
+}
+
+func hashWith(p HashParams, password string) (string, error) {
+	if p.Algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// validArgon2Params checks argon2id parameters are ones argon2.IDKey accepts and within argon2MaxMemory
+func validArgon2Params(memory, iterations uint32, threads uint8) error {
+	if iterations < 1 || threads < 1 || memory < 8*uint32(threads) || memory > argon2MaxMemory {
+		return fmt.Errorf("invalid argon2id parameters m=%d t=%d p=%d", memory, iterations, threads)
+	}
+	return nil
+}
+
+// argon2Hash is a parsed argon2id PHC string
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2Hash(encoded string) (*argon2Hash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return nil, fmt.Errorf("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	if err := validArgon2Params(h.memory, h.time, h.threads); err != nil {
+		return nil, err
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(h.salt) == 0 || len(h.key) == 0 {
+		return nil, fmt.Errorf("argon2 hash has an empty salt or key")
+	}
+	return &h, nil
+}
+
+// verifyPassword checks a password against a stored argon2id or bcrypt hash. rehash is true
+// when the password matched but the hash was not made with the current parameters.
+func verifyPassword(encoded, password string) (ok, rehash bool) {
+	if strings.HasPrefix(encoded, "$"+HashArgon2id+"$") {
+		h, err := parseArgon2Hash(encoded)
+		if err != nil {
+			return false, false
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+		if subtle.ConstantTimeCompare(key, h.key) != 1 {
+			return false, false
+		}
+		p := hashParams
+		return true, p.Algorithm != HashArgon2id || h.memory != p.Argon2Memory || h.time != p.Argon2Time ||
+			h.threads != p.Argon2Threads || len(h.salt) != argon2SaltLen || len(h.key) != argon2KeyLen
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return true, hashParams.Algorithm != HashBcrypt || err != nil || cost != hashParams.BcryptCost
+}
+
+const dummyPassword = "rubxy-dummy-password"
+
+// dummyHash is verified against when a user does not exist, so that a failed login takes about
+// as long whether or not the username is taken. Configure remakes it with the configured
+// algorithm and parameters, which new and rehashed passwords use too.
+var dummyHash, _ = hashWith(hashParams, dummyPassword)
@@ -0,0 +1,186 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"rubxy/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHashParams keeps Argon2id cheap enough for tests
+var testHashParams = HashParams{Algorithm: HashArgon2id, Argon2Memory: 64, Argon2Time: 1, Argon2Threads: 1, BcryptCost: bcrypt.MinCost}
+
+func withHashParams(t *testing.T, p HashParams) {
+	saved := hashParams
+	hashParams = p
+	t.Cleanup(func() { hashParams = saved })
+}
+
+// withArgon2Field replaces one $-separated field of a PHC string
+func withArgon2Field(encoded string, i int, value string) string {
+	parts := strings.Split(encoded, "$")
+	parts[i] = value
+	return strings.Join(parts, "$")
+}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	withHashParams(t, testHashParams)
+
+	encoded, err := hashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q is not in the expected PHC format", encoded)
+	}
+	h, err := parseArgon2Hash(encoded)
+	if err != nil {
+		t.Fatalf("parseArgon2Hash: %v", err)
+	}
+	if len(h.salt) != argon2SaltLen || len(h.key) != argon2KeyLen {
+		t.Errorf("salt and key are %d and %d bytes, want %d and %d", len(h.salt), len(h.key), argon2SaltLen, argon2KeyLen)
+	}
+	if again, _ := hashPassword("correct horse battery staple"); again == encoded {
+		t.Error("two hashes of the same password are equal; the salt is not random")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	withHashParams(t, testHashParams)
+	argonHash, err := hashPassword("s3cret-password")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("s3cret-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	stronger := testHashParams
+	stronger.Argon2Time = 2
+	asBcrypt := testHashParams
+	asBcrypt.Algorithm = HashBcrypt
+
+	tests := []struct {
+		name       string
+		params     HashParams
+		encoded    string
+		password   string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"argon2id match", testHashParams, argonHash, "s3cret-password", true, false},
+		{"argon2id mismatch", testHashParams, argonHash, "s3cret-passwort", false, false},
+		{"argon2id with new parameters", stronger, argonHash, "s3cret-password", true, true},
+		{"argon2id after switching to bcrypt", asBcrypt, argonHash, "s3cret-password", true, true},
+		{"bcrypt after switching to argon2id", testHashParams, string(bcryptHash), "s3cret-password", true, true},
+		{"bcrypt match", asBcrypt, string(bcryptHash), "s3cret-password", true, false},
+		{"bcrypt mismatch", asBcrypt, string(bcryptHash), "wrong", false, false},
+		{"argon2i is not argon2id", testHashParams, strings.Replace(argonHash, "argon2id", "argon2i", 1), "s3cret-password", false, false},
+		{"unsupported version", testHashParams, strings.Replace(argonHash, "v=19", "v=16", 1), "s3cret-password", false, false},
+		{"malformed parameters", testHashParams, strings.Replace(argonHash, "m=64,t=1,p=1", "m=64", 1), "s3cret-password", false, false},
+		{"zero iterations", testHashParams, strings.Replace(argonHash, "t=1", "t=0", 1), "s3cret-password", false, false},
+		{"zero threads", testHashParams, strings.Replace(argonHash, "p=1", "p=0", 1), "s3cret-password", false, false},
+		{"memory below 8 KiB per thread", testHashParams, strings.Replace(argonHash, "m=64,t=1,p=1", "m=7,t=1,p=1", 1), "s3cret-password", false, false},
+		{"memory above the maximum", testHashParams, strings.Replace(argonHash, "m=64", "m=4194304", 1), "s3cret-password", false, false},
+		{"empty salt", testHashParams, withArgon2Field(argonHash, 4, ""), "s3cret-password", false, false},
+		{"empty key", testHashParams, withArgon2Field(argonHash, 5, ""), "s3cret-password", false, false},
+		{"empty hash", testHashParams, "", "s3cret-password", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHashParams(t, tt.params)
+			ok, rehash := verifyPassword(tt.encoded, tt.password)
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("verifyPassword = (%v, %v), want (%v, %v)", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestParseArgon2HashRejects(t *testing.T) {
+	withHashParams(t, testHashParams)
+	valid, err := hashPassword("s3cret-password")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"t=0", strings.Replace(valid, "t=1", "t=0", 1)},
+		{"p=0", strings.Replace(valid, "p=1", "p=0", 1)},
+		{"m=0", strings.Replace(valid, "m=64", "m=0", 1)},
+		{"m too large", strings.Replace(valid, "m=64", "m=4194304", 1)},
+		{"empty salt", withArgon2Field(valid, 4, "")},
+		{"empty key", withArgon2Field(valid, 5, "")},
+		{"invalid salt encoding", withArgon2Field(valid, 4, "!!")},
+	}
+	if _, err := parseArgon2Hash(valid); err != nil {
+		t.Fatalf("parseArgon2Hash(valid): %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseArgon2Hash(tt.encoded); err == nil {
+				t.Errorf("parseArgon2Hash(%q) accepted the hash", tt.encoded)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordBcryptLength(t *testing.T) {
+	asBcrypt := testHashParams
+	asBcrypt.Algorithm = HashBcrypt
+	long := strings.Repeat("correct-horse-", 6) // 84 bytes
+
+	tests := []struct {
+		name    string
+		params  HashParams
+		wantErr bool
+	}{
+		{"argon2id takes long passwords", testHashParams, false},
+		{"bcrypt rejects over 72 bytes", asBcrypt, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHashParams(t, tt.params)
+			err := ValidatePassword("alice", long)
+			if _, isPolicy := err.(*PolicyError); isPolicy != tt.wantErr || (err != nil && !isPolicy) {
+				t.Errorf("ValidatePassword = %v, want policy error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigureRemakesDummyHash(t *testing.T) {
+	savedParams, savedPolicy, savedDummy, savedRegistration := hashParams, policy, dummyHash, registration
+	t.Cleanup(func() {
+		hashParams, policy, dummyHash, registration = savedParams, savedPolicy, savedDummy, savedRegistration
+	})
+
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		prefix string
+	}{
+		{"argon2id", config.Config{PasswordHash: HashArgon2id, Argon2Memory: 64, Argon2Time: 1, Argon2Threads: 1}, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"bcrypt", config.Config{PasswordHash: HashBcrypt, BcryptCost: bcrypt.MinCost}, "$2a$04$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.RegistrationMode = 8, 128, RegistrationOpen
+			if err := Configure(&cfg); err != nil {
+				t.Fatalf("Configure: %v", err)
+			}
+			// The dummy hash must cost what a real hash costs, so it needs no rehash
+			if !strings.HasPrefix(dummyHash, tt.prefix) {
+				t.Errorf("dummy hash %q does not start with %q", dummyHash, tt.prefix)
+			}
+			if ok, rehash := verifyPassword(dummyHash, dummyPassword); !ok || rehash {
+				t.Errorf("verifyPassword(dummyHash) = (%v, %v), want (true, false)", ok, rehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"rubxy/config"
+	"rubxy/db"
+
+	"github.com/lib/pq"
+)
+
+// Registration modes
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+const (
+	usernameMinLength = 3
+	usernameMaxLength = 32
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// defaultReservedUsernames cannot be registered, in any letter case. RESERVED_USERNAMES adds to them.
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "rubxy", "support", "security", "api", "oauth", "null", "me",
+}
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInviteRequired     = errors.New("an invite code is required to register")
+	ErrInvalidInvite      = errors.New("invite code is invalid, expired or used up")
+	ErrUsernameTaken      = errors.New("username is already taken")
+)
+
+// LoginError is returned by CheckLogin when an account exists but may not log in yet
+type LoginError struct {
+	Reason string
+}
+
+func (e *LoginError) Error() string {
+	return e.Reason
+}
+
+// Registration is a request to create an account. InviteCodeHash is the hash of the invite
+// code given, if any.
+type Registration struct {
+	Username       string
+	Password       string
+	Email          string
+	InviteCodeHash string
+}
+
+type registrationSettings struct {
+	mode        string
+	verifyEmail bool
+	reserved    map[string]struct{}
+}
+
+var registration = registrationSettings{mode: RegistrationOpen, reserved: reservedSet(nil)}
+
+func configureRegistration(cfg *config.Config) error {
+	switch cfg.RegistrationMode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		return fmt.Errorf("unknown registration mode %q, expected %s, %s or %s",
+			cfg.RegistrationMode, RegistrationOpen, RegistrationInvite, RegistrationClosed)
+	}
+	registration = registrationSettings{
+		mode:        cfg.RegistrationMode,
+		verifyEmail: cfg.RequireEmailVerification,
+		reserved:    reservedSet(cfg.ReservedUsernames),
+	}
+	return nil
+}
+
+func reservedSet(extra []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, name := range append(defaultReservedUsernames, extra...) {
+		set[strings.ToLower(name)] = struct{}{}
+	}
+	return set
+}
+
+// RegistrationMode returns the configured registration mode
+func RegistrationMode() string {
+	return registration.mode
+}
+
+// ValidateUsername checks a new username against the length, charset and reserved name rules
+// and returns a *PolicyError if it is not acceptable
+func ValidateUsername(username string) error {
+	if len(username) < usernameMinLength || len(username) > usernameMaxLength {
+		return &PolicyError{Field: "Username", Reason: fmt.Sprintf("must be %d to %d characters", usernameMinLength, usernameMaxLength)}
+	}
+	if !usernamePattern.MatchString(username) {
+		return &PolicyError{Field: "Username", Reason: "may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit"}
+	}
+	if _, ok := registration.reserved[strings.ToLower(username)]; ok {
+		return &PolicyError{Field: "Username", Reason: "is reserved"}
+	}
+	return nil
+}
+
+func validateEmail(email string) error {
+	if email == "" {
+		if registration.verifyEmail {
+			return &PolicyError{Field: "Email", Reason: "is required"}
+		}
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return &PolicyError{Field: "Email", Reason: "is not a valid address"}
+	}
+	return nil
+}
+
+// Register creates an account under the configured registration mode. pending is true when
+// the email address has to be verified before the user can log in.
+func Register(reg Registration) (pending bool, err error) {
+	switch registration.mode {
+	case RegistrationClosed:
+		return false, ErrRegistrationClosed
+	case RegistrationInvite:
+		if reg.InviteCodeHash == "" {
+			return false, ErrInviteRequired
+		}
+	}
+
+	if err := ValidateUsername(reg.Username); err != nil {
+		return false, err
+	}
+	if err := ValidatePassword(reg.Username, reg.Password); err != nil {
+		return false, err
+	}
+	if err := validateEmail(reg.Email); err != nil {
+		return false, err
+	}
+	hash, err := hashPassword(reg.Password)
+	if err != nil {
+		return false, fmt.Errorf("password hash error: %w", err)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var inviteID sql.NullInt64
+	if registration.mode == RegistrationInvite {
+		id, err := db.RedeemInviteCode(tx, reg.InviteCodeHash)
+		if err != nil {
+			return false, fmt.Errorf("failed to redeem invite code: %w", err)
+		}
+		if id == 0 {
+			return false, ErrInvalidInvite
+		}
+		inviteID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	emailStatus := db.EmailUnverified
+	if registration.verifyEmail {
+		emailStatus = db.EmailPending
+	}
+	_, err = tx.Exec("INSERT INTO users (username, password_hash, email, email_status, invite_id) VALUES ($1, $2, $3, $4, $5)",
+		reg.Username, hash, reg.Email, emailStatus, inviteID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return false, ErrUsernameTaken
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to insert user: %w", err)
+	}
+	return emailStatus == db.EmailPending, tx.Commit()
+}
+
+// PendingEmail returns the email address of a user whose verification is pending, or "" if
+// the user does not exist or has nothing to verify
+func PendingEmail(username string) (string, error) {
+	var email, status string
+	err := db.DB.QueryRow("SELECT email, email_status FROM users WHERE username=$1", username).Scan(&email, &status)
+	if err == sql.ErrNoRows || status != db.EmailPending {
+		return "", nil
+	}
+	return email, err
+}
+
+// CheckLogin returns a *LoginError if a user whose password was accepted may not log in yet
+func CheckLogin(username string) error {
+	var status string
+	var disabled bool
+	err := db.DB.QueryRow("SELECT email_status, disabled_at IS NOT NULL FROM users WHERE username=$1", username).
+		Scan(&status, &disabled)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		return &LoginError{Reason: "Account is disabled"}
+	}
+	if status == db.EmailPending {
+		return &LoginError{Reason: "Email address has not been verified"}
+	}
+	return nil
+}